@@ -0,0 +1,69 @@
+package fb
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrUnsigned is a webhook POST without an X-Hub-Signature(-256) header.
+	ErrUnsigned = errors.New("request is not signed")
+	// ErrBadSignature is a signature that doesn't match the body, so it didn't come from Facebook.
+	ErrBadSignature = errors.New("request signature doesn't match")
+	// ErrNoSecret is an empty app secret, as on a fresh checkout before the app's
+	// settings are copied in. It's a fault in the server, not in the request.
+	ErrNoSecret = errors.New("no app secret to check the signature with")
+)
+
+// CheckSignature verifies the HMAC Facebook signs webhook bodies with, using the
+// app secret. SHA-256 is preferred, falling back to the older SHA-1 header.
+func CheckSignature(appSecret string, header http.Header, body []byte) error {
+	if appSecret == "" {
+		return ErrNoSecret
+	}
+	newHash, signature := sha256.New, header.Get("X-Hub-Signature-256")
+	prefix := "sha256="
+	if signature == "" {
+		newHash, signature = sha1.New, header.Get("X-Hub-Signature")
+		prefix = "sha1="
+	}
+	if signature == "" {
+		return ErrUnsigned
+	}
+	if !strings.HasPrefix(signature, prefix) {
+		return ErrBadSignature
+	}
+	given, err := hex.DecodeString(signature[len(prefix):])
+	if err != nil {
+		return ErrBadSignature
+	}
+	if !hmac.Equal(given, sign(newHash, appSecret, body)) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func sign(newHash func() hash.Hash, secret string, body []byte) []byte {
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// SubscribeChallenge answers the GET Facebook sends when the webhook is set up:
+// the hub.challenge to echo back, or false if the verify token doesn't match.
+func SubscribeChallenge(query url.Values, verifyToken string) (string, bool) {
+	if query.Get("hub.mode") != "subscribe" || verifyToken == "" {
+		return "", false
+	}
+	if !hmac.Equal([]byte(query.Get("hub.verify_token")), []byte(verifyToken)) {
+		return "", false
+	}
+	return query.Get("hub.challenge"), true
+}
@@ -0,0 +1,48 @@
+package fb
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+)
+
+func TestCheckSignature(t *testing.T) {
+	body := []byte(`{"entry":[]}`)
+	sha256Signature := "sha256=" + hex.EncodeToString(sign(sha256.New, "s3cret", body))
+	sha1Signature := "sha1=" + hex.EncodeToString(sign(sha1.New, "s3cret", body))
+
+	tests := []struct {
+		name      string
+		signature string // X-Hub-Signature-256
+		sha1      string // X-Hub-Signature
+		want      error
+	}{
+		{"SHA-256", sha256Signature, "", nil},
+		{"SHA-1 alone", "", sha1Signature, nil},
+		{"SHA-256 over SHA-1", sha256Signature, "sha1=00", nil},
+		{"SHA-256 no good", "sha256=00", sha1Signature, ErrBadSignature},
+		{"SHA-1 in the SHA-256 header", sha1Signature, "", ErrBadSignature},
+		{"not hex", "sha256=zz", "", ErrBadSignature},
+		{"neither", "", "", ErrUnsigned},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.signature != "" {
+			header.Set("X-Hub-Signature-256", test.signature)
+		}
+		if test.sha1 != "" {
+			header.Set("X-Hub-Signature", test.sha1)
+		}
+		if got := CheckSignature("s3cret", header, body); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(sign(sha256.New, "", body)))
+	if got := CheckSignature("", header, body); got != ErrNoSecret {
+		t.Errorf("no app secret: got %v, want %v", got, ErrNoSecret)
+	}
+}
@@ -0,0 +1,76 @@
+package slack
+
+// SlashCommand is the form Slack POSTs when someone uses a slash command.
+type SlashCommand struct {
+	TeamId      string
+	ChannelId   string
+	UserId      string
+	Command     string // e.g. "/caltrain"
+	Text        string // Everything after the command.
+	ResponseURL string
+}
+
+// Interaction is the JSON payload Slack POSTs when someone presses a button.
+type Interaction struct {
+	Type        string   `json:"type"`
+	Team        Team     `json:"team"`
+	User        User     `json:"user"`
+	ResponseURL string   `json:"response_url"`
+	Actions     []Action `json:"actions"`
+}
+
+type Team struct {
+	Id string `json:"id"`
+}
+
+type User struct {
+	Id string `json:"id"`
+}
+
+type Action struct {
+	ActionId string `json:"action_id"`
+	Value    string `json:"value,omitempty"`
+}
+
+type Message struct {
+	ResponseType    string  `json:"response_type,omitempty"` // "ephemeral" (the default) or "in_channel"
+	ReplaceOriginal bool    `json:"replace_original"`
+	Text            string  `json:"text"` // Shown in notifications, and where blocks can't be.
+	Blocks          []Block `json:"blocks,omitempty"`
+}
+
+type Block struct {
+	Type     string      `json:"type"`
+	Text     *TextObject `json:"text,omitempty"`
+	Elements []Element   `json:"elements,omitempty"`
+}
+
+type TextObject struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type Element struct {
+	Type     string     `json:"type"`
+	Text     TextObject `json:"text"`
+	ActionId string     `json:"action_id"`
+	Value    string     `json:"value,omitempty"`
+	URL      string     `json:"url,omitempty"`
+}
+
+func SectionBlock(text string) Block {
+	return Block{"section", &TextObject{"plain_text", text}, nil}
+}
+
+func ActionsBlock(elements []Element) Block {
+	return Block{"actions", nil, elements}
+}
+
+// Button sends value back in an Interaction when pressed.
+func Button(caption string, actionId string, value string) Element {
+	return Element{"button", TextObject{"plain_text", caption}, actionId, value, ""}
+}
+
+func LinkButton(caption string, actionId string, url string) Element {
+	return Element{"button", TextObject{"plain_text", caption}, actionId, "", url}
+}
@@ -0,0 +1,102 @@
+package slack
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Requests signed longer ago than this are refused, so they can't be replayed.
+const MAX_REQUEST_AGE = 5 * time.Minute
+
+var (
+	// ErrUnsigned is a request without the X-Slack-Signature and timestamp headers.
+	ErrUnsigned = errors.New("request is not signed")
+	// ErrBadSignature is a signature that doesn't match the body, so it didn't come from Slack.
+	ErrBadSignature = errors.New("request signature doesn't match")
+	// ErrStale is a request signed too long ago.
+	ErrStale = errors.New("request is too old")
+	// ErrNoSecret is an empty signing secret, which Slack shows under the app's Basic Information.
+	ErrNoSecret = errors.New("no signing secret to check the signature with")
+)
+
+// CheckSignature verifies the HMAC-SHA256 Slack signs requests with, using the app's
+// signing secret, over the version, the request timestamp and the body.
+func CheckSignature(signingSecret string, header http.Header, body []byte, now time.Time) error {
+	if signingSecret == "" {
+		return ErrNoSecret
+	}
+	signature, timestamp := header.Get("X-Slack-Signature"), header.Get("X-Slack-Request-Timestamp")
+	if signature == "" || timestamp == "" {
+		return ErrUnsigned
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > MAX_REQUEST_AGE || age < -MAX_REQUEST_AGE {
+		return ErrStale
+	}
+	if !strings.HasPrefix(signature, "v0=") {
+		return ErrBadSignature
+	}
+	given, err := hex.DecodeString(signature[len("v0="):])
+	if err != nil {
+		return ErrBadSignature
+	}
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	if !hmac.Equal(given, mac.Sum(nil)) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func ParseSlashCommand(form url.Values) SlashCommand {
+	return SlashCommand{
+		form.Get("team_id"),
+		form.Get("channel_id"),
+		form.Get("user_id"),
+		form.Get("command"),
+		form.Get("text"),
+		form.Get("response_url"),
+	}
+}
+
+// ParseInteraction reads the JSON payload out of an interaction's form.
+func ParseInteraction(form url.Values) (Interaction, error) {
+	var interaction Interaction
+	err := json.Unmarshal([]byte(form.Get("payload")), &interaction)
+	return interaction, err
+}
+
+// Respond POSTs a message to a response_url, which is good for a few replies to a
+// command or interaction, for up to half an hour.
+func Respond(client *http.Client, responseURL string, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	r, err := client.Post(responseURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		response, _ := ioutil.ReadAll(r.Body)
+		return fmt.Errorf("response_url: %s %s", r.Status, response)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCheckSignature(t *testing.T) {
+	body := []byte("team_id=T1&user_id=U1&command=%2Fcaltrain&text=next+3+SB")
+	signedAt := time.Unix(1700000000, 0)
+	signature := func(secret string, timestamp string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte("v0:" + timestamp + ":"))
+		mac.Write(body)
+		return "v0=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	timestamp := fmt.Sprint(signedAt.Unix())
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		now       time.Time
+		want      error
+	}{
+		{"just sent", timestamp, signature("s3cret", timestamp), signedAt.Add(time.Minute), nil},
+		{"clocks apart", timestamp, signature("s3cret", timestamp), signedAt.Add(-time.Minute), nil},
+		{"replayed", timestamp, signature("s3cret", timestamp), signedAt.Add(MAX_REQUEST_AGE + time.Second), ErrStale},
+		{"from the future", timestamp, signature("s3cret", timestamp), signedAt.Add(-MAX_REQUEST_AGE - time.Second), ErrStale},
+		{"timestamp changed", fmt.Sprint(signedAt.Unix() + 1), signature("s3cret", timestamp), signedAt, ErrBadSignature},
+		{"timestamp not a number", "soon", signature("s3cret", "soon"), signedAt, ErrBadSignature},
+		{"other version", timestamp, "v1=" + signature("s3cret", timestamp)[len("v0="):], signedAt, ErrBadSignature},
+		{"no timestamp", "", signature("s3cret", ""), signedAt, ErrUnsigned},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		header.Set("X-Slack-Signature", test.signature)
+		if test.timestamp != "" {
+			header.Set("X-Slack-Request-Timestamp", test.timestamp)
+		}
+		if got := CheckSignature("s3cret", header, body, test.now); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("X-Slack-Request-Timestamp", timestamp)
+	header.Set("X-Slack-Signature", signature("", timestamp))
+	if got := CheckSignature("", header, body, signedAt); got != ErrNoSecret {
+		t.Errorf("no signing secret: got %v, want %v", got, ErrNoSecret)
+	}
+}
@@ -0,0 +1,60 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	API_URL = "https://api.telegram.org"
+	// Longest CallbackData the Bot API accepts, in bytes.
+	MAX_CALLBACK_DATA = 64
+)
+
+// Bot calls Bot API methods. BaseURL is normally API_URL, but can point at a
+// fake server to try things out locally.
+type Bot struct {
+	BaseURL string
+	Token   string
+	Client  *http.Client
+}
+
+type apiResponse struct {
+	Ok          bool   `json:"ok"`
+	Description string `json:"description"`
+}
+
+// Call POSTs params as JSON to a Bot API method, erroring unless the API says it's ok.
+func (b Bot) Call(method string, params interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(b.BaseURL, "/") + "/bot" + b.Token + "/" + method
+	r, err := b.Client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	var result apiResponse
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		return fmt.Errorf("%s: bad response (%s): %v", method, r.Status, err)
+	}
+	if !result.Ok {
+		return fmt.Errorf("%s: %s", method, result.Description)
+	}
+	return nil
+}
+
+func (b Bot) SendMessage(msg SendMessage) error {
+	return b.Call("sendMessage", msg)
+}
+
+// AnswerCallbackQuery stops the client showing a button press as still loading.
+func (b Bot) AnswerCallbackQuery(id string) error {
+	return b.Call("answerCallbackQuery", AnswerCallbackQuery{id})
+}
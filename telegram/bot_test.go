@@ -0,0 +1,37 @@
+package telegram
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBotCall(t *testing.T) {
+	calls := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		calls = append(calls, r.URL.Path+" "+string(body))
+		if r.URL.Path == "/bot123:abc/answerCallbackQuery" {
+			w.Write([]byte(`{"ok":false,"description":"Bad Request: query is too old"}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	}))
+	defer server.Close()
+	bot := Bot{server.URL + "/", "123:abc", server.Client()}
+
+	if err := bot.SendMessage(SendMessage{ChatId: 99, Text: "hi"}); err != nil {
+		t.Errorf("SendMessage: %v", err)
+	}
+	if err := bot.AnswerCallbackQuery("cb1"); err == nil || err.Error() != "answerCallbackQuery: Bad Request: query is too old" {
+		t.Errorf("AnswerCallbackQuery: got %v, want the API's description", err)
+	}
+	want := []string{
+		`/bot123:abc/sendMessage {"chat_id":99,"text":"hi"}`,
+		`/bot123:abc/answerCallbackQuery {"callback_query_id":"cb1"}`,
+	}
+	if len(calls) != len(want) || calls[0] != want[0] || calls[1] != want[1] {
+		t.Errorf("got calls %q, want %q", calls, want)
+	}
+}
@@ -0,0 +1,65 @@
+package telegram
+
+type Update struct {
+	UpdateId      int64          `json:"update_id"`
+	Message       *Message       `json:"message,omitempty"`
+	CallbackQuery *CallbackQuery `json:"callback_query,omitempty"`
+}
+
+type Message struct {
+	MessageId int64     `json:"message_id"`
+	From      *User     `json:"from,omitempty"`
+	Chat      Chat      `json:"chat"`
+	Text      string    `json:"text,omitempty"`
+	Location  *Location `json:"location,omitempty"`
+}
+
+type User struct {
+	Id        int64  `json:"id"`
+	FirstName string `json:"first_name,omitempty"`
+	Username  string `json:"username,omitempty"`
+}
+
+type Chat struct {
+	Id   int64  `json:"id"`
+	Type string `json:"type"`
+}
+
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// CallbackQuery is a press of an inline keyboard button, carrying its CallbackData.
+type CallbackQuery struct {
+	Id      string   `json:"id"`
+	From    User     `json:"from"`
+	Message *Message `json:"message,omitempty"`
+	Data    string   `json:"data,omitempty"`
+}
+
+type SendMessage struct {
+	ChatId                int64                 `json:"chat_id"`
+	Text                  string                `json:"text"`
+	DisableWebPagePreview bool                  `json:"disable_web_page_preview,omitempty"`
+	ReplyMarkup           *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	URL          string `json:"url,omitempty"`
+	CallbackData string `json:"callback_data,omitempty"`
+}
+
+// AddRow adds a row of buttons below the existing ones.
+func (k *InlineKeyboardMarkup) AddRow(buttons ...InlineKeyboardButton) {
+	k.InlineKeyboard = append(k.InlineKeyboard, buttons)
+}
+
+type AnswerCallbackQuery struct {
+	CallbackQueryId string `json:"callback_query_id"`
+}
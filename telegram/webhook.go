@@ -0,0 +1,37 @@
+package telegram
+
+import (
+	"crypto/hmac"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrBadSecretToken is a webhook request without the secret token given to setWebhook.
+var ErrBadSecretToken = errors.New("secret token doesn't match")
+
+// CheckSecretToken verifies the X-Telegram-Bot-Api-Secret-Token header Telegram
+// sends with each update, set by the secret_token given to setWebhook.
+func CheckSecretToken(secretToken string, header http.Header) error {
+	given := header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if secretToken == "" || !hmac.Equal([]byte(given), []byte(secretToken)) {
+		return ErrBadSecretToken
+	}
+	return nil
+}
+
+// CommandText is a message with any bot command turned into plain text,
+// e.g. "/next@TripTimeBot 3 SB" is "next 3 SB".
+func (m *Message) CommandText() string {
+	if !strings.HasPrefix(m.Text, "/") {
+		return m.Text
+	}
+	command, args := m.Text[1:], ""
+	if space := strings.IndexAny(command, " \n"); space != -1 {
+		command, args = command[:space], command[space:]
+	}
+	if at := strings.Index(command, "@"); at != -1 {
+		command = command[:at]
+	}
+	return command + args
+}
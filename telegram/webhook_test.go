@@ -0,0 +1,47 @@
+package telegram
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCheckSecretToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		secretToken string
+		given       string
+		want        error
+	}{
+		{"matches", "s3cret", "s3cret", nil},
+		{"doesn't match", "s3cret", "guess", ErrBadSecretToken},
+		{"missing", "s3cret", "", ErrBadSecretToken},
+		{"no secret token", "", "", ErrBadSecretToken},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.given != "" {
+			header.Set("X-Telegram-Bot-Api-Secret-Token", test.given)
+		}
+		if got := CheckSecretToken(test.secretToken, header); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCommandText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"next 3 SB", "next 3 SB"},
+		{"/next 3 SB", "next 3 SB"},
+		{"/next@TripTimeBot 3 SB", "next 3 SB"},
+		{"/help@TripTimeBot", "help"},
+	}
+	for _, test := range tests {
+		m := Message{Text: test.text}
+		if got := m.CommandText(); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
@@ -1,283 +1,384 @@
 package triptime
 
 import (
-	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
 	"os"
-	"reflect"
-	"strconv"
+	"sort"
+	"time"
+
+	"github.com/padster/triptime/fb"
 )
 
-// OLD: stop_id,stop_code,stop_name,stop_lat,stop_lon,zone_id,stop_url,location_type,parent_station,platform_code,wheelchair_boarding
-// stop_id,stop_code,stop_name,stop_desc,stop_lat,stop_lon,zone_id,stop_url,location_type,parent_station,platform_code,wheelchair_boarding
+// stops.txt
 type Stop struct {
-	StopId   string
-	Code     string
-	Name     string
-  Desc     string
-	Lat      float64
-	Long     float64
-	ZoneId   string
-	StopUrl  string
-	Type     int
-	Parent   string
-	PlatCode string
-	WChair   int
+	StopId   string  `csv:"stop_id"`
+	Code     string  `csv:"stop_code,optional"`
+	Name     string  `csv:"stop_name"`
+	Desc     string  `csv:"stop_desc,optional"`
+	Lat      float64 `csv:"stop_lat"`
+	Long     float64 `csv:"stop_lon"`
+	ZoneId   string  `csv:"zone_id,optional"`
+	StopUrl  string  `csv:"stop_url,optional"`
+	Type     int     `csv:"location_type,optional"` // LOCATION_STOP, LOCATION_STATION, ...
+	Parent   string  `csv:"parent_station,optional"`
+	PlatCode string  `csv:"platform_code,optional"`
+	WChair   int     `csv:"wheelchair_boarding,optional"`
+	Timezone string  `csv:"stop_timezone,optional"`
+}
+
+// stops.txt location_type values.
+const (
+	LOCATION_STOP     = 0 // A stop, or a platform if it has a parent station.
+	LOCATION_STATION  = 1
+	LOCATION_ENTRANCE = 2
+	LOCATION_NODE     = 3
+	LOCATION_BOARDING = 4
+)
+
+// IsStation is true for stations, and for stops that aren't part of one.
+func (s Stop) IsStation() bool {
+	return s.Type == LOCATION_STATION || (s.Type == LOCATION_STOP && s.Parent == "")
+}
+
+// transfers.txt
+type Transfer struct {
+	FromStopId string `csv:"from_stop_id"`
+	ToStopId   string `csv:"to_stop_id"`
+	Type       int    `csv:"transfer_type,optional"`     // TRANSFER_RECOMMENDED, TRANSFER_TIMED, ...
+	MinTime    int    `csv:"min_transfer_time,optional"` // Seconds, for TRANSFER_MIN_TIME.
+	// Narrow a transfer to particular trains. Changes are planned between stops, so
+	// transfers narrowed like this are left out.
+	FromRouteId string `csv:"from_route_id,optional"`
+	ToRouteId   string `csv:"to_route_id,optional"`
+	FromTripId  string `csv:"from_trip_id,optional"`
+	ToTripId    string `csv:"to_trip_id,optional"`
+}
+
+// transfers.txt transfer_type values.
+const (
+	TRANSFER_RECOMMENDED  = 0
+	TRANSFER_TIMED        = 1 // The departing train waits for the arriving one.
+	TRANSFER_MIN_TIME     = 2
+	TRANSFER_NOT_POSSIBLE = 3
+)
+
+// agency.txt
+type Agency struct {
+	AgencyId string `csv:"agency_id,optional"`
+	Name     string `csv:"agency_name"`
+	Url      string `csv:"agency_url"`
+	Timezone string `csv:"agency_timezone"`
+	Lang     string `csv:"agency_lang,optional"`
+	Phone    string `csv:"agency_phone,optional"`
 }
 
-// trip_id,arrival_time,departure_time,stop_id,stop_sequence,pickup_type,drop_off_type
+// stop_times.txt
 type StopTime struct {
-	TripId      string
-	Arrival     string
-	Departure   string
-	StopId      string
-	StopSeq     int
-	PickupType  string // ignore, always 0?
-	DropoffType string // ignore, always 0?
+	TripId      string       `csv:"trip_id"`
+	Arrival     GTFSTime     `csv:"arrival_time"`
+	Departure   GTFSTime     `csv:"departure_time"`
+	StopId      string       `csv:"stop_id"`
+	StopSeq     int          `csv:"stop_sequence"`
+	PickupType  BoardingType `csv:"pickup_type,optional"`
+	DropoffType BoardingType `csv:"drop_off_type,optional"`
 }
 
-// OLD: route_id,service_id,trip_id,trip_headsign,trip_short_name,direction_id,shape_id,wheelchair_accessible,bikes_allowed
-// route_id,service_id,trip_id,trip_headsign,trip_short_name,direction_id,block_id,shape_id,wheelchair_accessible,bikes_allowed
+// stop_times.txt pickup_type and drop_off_type values, whether passengers can get on (or off).
+type BoardingType int
+
+const (
+	BOARDING_REGULAR      BoardingType = 0
+	BOARDING_NONE         BoardingType = 1
+	BOARDING_PHONE_AGENCY BoardingType = 2
+	BOARDING_ASK_DRIVER   BoardingType = 3 // Request stops.
+)
+
+func (b *BoardingType) UnmarshalCSV(value string) error {
+	switch value {
+	case "", "0":
+		*b = BOARDING_REGULAR
+	case "1":
+		*b = BOARDING_NONE
+	case "2":
+		*b = BOARDING_PHONE_AGENCY
+	case "3":
+		*b = BOARDING_ASK_DRIVER
+	default:
+		return fmt.Errorf("%w: %q is not a pickup/drop-off type", ErrBadValue, value)
+	}
+	return nil
+}
+
+// Allowed is whether passengers can get on (or off) at all, perhaps by arrangement.
+func (b BoardingType) Allowed() bool {
+	return b != BOARDING_NONE
+}
+
+// trips.txt
 type Trip struct {
-	RouteId     string
-	ServiceId   string
-	TripId      string
-	HeadSign    string
-	ShortName   string
-	DirectionId string
-  BlockId     string
-	ShapeId     string
-	WChair      bool
-	Bikes       bool
+	RouteId     string `csv:"route_id"`
+	ServiceId   string `csv:"service_id"`
+	TripId      string `csv:"trip_id"`
+	HeadSign    string `csv:"trip_headsign,optional"`
+	ShortName   string `csv:"trip_short_name,optional"`
+	DirectionId string `csv:"direction_id,optional"`
+	BlockId     string `csv:"block_id,optional"`
+	ShapeId     string `csv:"shape_id,optional"`
+	WChair      bool   `csv:"wheelchair_accessible,optional"`
+	Bikes       bool   `csv:"bikes_allowed,optional"`
 }
 
-// service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date
+// calendar.txt
 type ServiceDate struct {
-	ServiceId string
-	OnMon     bool
-	OnTue     bool
-	OnWed     bool
-	OnThu     bool
-	OnFri     bool
-	OnSat     bool
-	OnSun     bool
-	StartDate string // ignore
-	EndDate   string // ignore
+	ServiceId string `csv:"service_id"`
+	OnMon     bool   `csv:"monday"`
+	OnTue     bool   `csv:"tuesday"`
+	OnWed     bool   `csv:"wednesday"`
+	OnThu     bool   `csv:"thursday"`
+	OnFri     bool   `csv:"friday"`
+	OnSat     bool   `csv:"saturday"`
+	OnSun     bool   `csv:"sunday"`
+	StartDate string `csv:"start_date"` // YYYYMMDD, inclusive
+	EndDate   string `csv:"end_date"`   // YYYYMMDD, inclusive
 }
 
-// service_id,date,exception_type
+// calendar_dates.txt
 type ServiceDateException struct {
-	ServiceId     string
-	Date          string // YYYYMMDD
-	ExceptionType int    // 1 = new service, 2 = old service
+	ServiceId     string `csv:"service_id"`
+	Date          string `csv:"date"`           // YYYYMMDD
+	ExceptionType int    `csv:"exception_type"` // SERVICE_ADDED or SERVICE_REMOVED
 }
 
-// OLD: route_id,route_short_name,route_long_name,route_type,route_color
-// route_id,route_short_name,route_long_name,route_desc,route_type,route_url,route_color
+// routes.txt
 type Route struct {
-	RouteId   string
-	ShortName string
-	LongName  string
-  Desc      string
-	Type      int
-  RouteUrl  string
-	Color     string
+	RouteId   string `csv:"route_id"`
+	AgencyId  string `csv:"agency_id,optional"`
+	ShortName string `csv:"route_short_name,optional"`
+	LongName  string `csv:"route_long_name,optional"`
+	Desc      string `csv:"route_desc,optional"`
+	Type      int    `csv:"route_type"`
+	RouteUrl  string `csv:"route_url,optional"`
+	Color     string `csv:"route_color,optional"`
 }
 
-func ReadStops() []Stop {
-	f, err := os.Open("gtfs/stops.txt")
-	defer f.Close()
-	if err != nil {
-		panic("can't open file...")
+// LoadError reports which feed file stopped LoadGTFS.
+type LoadError struct {
+	File string
+	Err  error
+	Feed string // Set by LoadFeeds, when there's more than one.
+}
+
+func (e *LoadError) Error() string {
+	feed := "GTFS"
+	if e.Feed != "" {
+		feed = "GTFS feed " + e.Feed
 	}
-	reader := csv.NewReader(f)
-
-	result := []Stop{}
-	for i := 0; ; i++ {
-		if i == 0 {
-			// First row, ignore as it's column headers.
-			reader.Read()
-		} else {
-			row := Stop{}
-			if err := Unmarshal(reader, &row); err != "" {
-				if err == "DONE" {
-					break
-				}
-				panic(err)
-			}
-			result = append(result, row)
-		}
+	if _, isCSV := e.Err.(*CSVError); isCSV {
+		// Already says which file and row.
+		return fmt.Sprintf("can't load %s: %v", feed, e.Err)
 	}
-	return result
+	return fmt.Sprintf("can't load %s %s: %v", feed, e.File, e.Err)
 }
 
-func ReadStopTimes() []StopTime {
-	f, err := os.Open("gtfs/stop_times.txt")
-	defer f.Close()
-	if err != nil {
-		panic("can't open file...")
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// LoadGTFS reads every file the bot needs from one feed.
+func LoadGTFS(src FeedSource) (*GTFSData, error) {
+	data := &GTFSData{}
+	var err error
+	if data.Agencies, err = ReadAgencies(src); err != nil {
+		return nil, &LoadError{File: "agency.txt", Err: err}
 	}
-	reader := csv.NewReader(f)
-
-	result := []StopTime{}
-	for i := 0; ; i++ {
-		if i == 0 {
-			// First row, ignore as it's column headers.
-			reader.Read()
-		} else {
-			row := StopTime{}
-			if err := Unmarshal(reader, &row); err != "" {
-				if err == "DONE" {
-					break
-				}
-				panic(err)
-			}
-			result = append(result, row)
+	if len(data.Agencies) == 0 {
+		return nil, &LoadError{File: "agency.txt", Err: errors.New("feed has no agency")}
+	}
+	// Every agency in a feed must share one timezone, so the first speaks for all.
+	if data.Location, err = time.LoadLocation(data.Agencies[0].Timezone); err != nil {
+		return nil, &LoadError{File: "agency.txt", Err: err}
+	}
+	if data.Routes, err = ReadRoutes(src); err != nil {
+		return nil, &LoadError{File: "routes.txt", Err: err}
+	}
+	if data.Stops, err = ReadStops(src); err != nil {
+		return nil, &LoadError{File: "stops.txt", Err: err}
+	}
+	if len(data.Stops) == 0 {
+		return nil, &LoadError{File: "stops.txt", Err: errors.New("feed has no stops")}
+	}
+	if data.stopLocations, err = loadStopLocations(data.Stops); err != nil {
+		return nil, &LoadError{File: "stops.txt", Err: err}
+	}
+	if data.StopTimes, err = ReadStopTimes(src); err != nil {
+		return nil, &LoadError{File: "stop_times.txt", Err: err}
+	}
+	data.StopTimes = interpolateStopTimes(data.StopTimes, data.Stops)
+	// Feeds may use either calendar file alone, but need at least one.
+	data.ServiceDates, err = ReadServiceDates(src)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, &LoadError{File: "calendar.txt", Err: err}
+	}
+	noCalendar := os.IsNotExist(err)
+	data.ServiceDateExceptions, err = ReadServiceDateExceptions(src)
+	if err != nil && (noCalendar || !os.IsNotExist(err)) {
+		return nil, &LoadError{File: "calendar_dates.txt", Err: err}
+	}
+	if data.Trips, err = ReadTrips(src); err != nil {
+		return nil, &LoadError{File: "trips.txt", Err: err}
+	}
+	// Optional, walking between nearby stops is assumed without it.
+	if data.Transfers, err = ReadTransfers(src); err != nil && !os.IsNotExist(err) {
+		return nil, &LoadError{File: "transfers.txt", Err: err}
+	}
+	// agency_id may be left out when there's only one agency.
+	for i := range data.Routes {
+		if data.Routes[i].AgencyId == "" {
+			data.Routes[i].AgencyId = data.Agencies[0].AgencyId
 		}
 	}
-	return result
+	data.buildIndex()
+	return data, nil
 }
 
-func ReadTrips() []Trip {
-	f, err := os.Open("gtfs/trips.txt")
-	defer f.Close()
-	if err != nil {
-		panic("can't open file...")
-	}
-	reader := csv.NewReader(f)
-
-	result := []Trip{}
-	for i := 0; ; i++ {
-		if i == 0 {
-			// First row, ignore as it's column headers.
-			reader.Read()
-		} else {
-			row := Trip{}
-			if err := Unmarshal(reader, &row); err != "" {
-				if err == "DONE" {
-					break
-				}
-				panic(err)
-			}
-			result = append(result, row)
+// interpolateStopTimes fills in blank times from the timed stops either side, in
+// proportion to the distance between stops. Untimed stops at the ends of a trip
+// can't be placed, so are dropped.
+func interpolateStopTimes(stopTimes []StopTime, stops []Stop) []StopTime {
+	byTrip := map[string][]*StopTime{}
+	untimed := map[string]bool{}
+	for i := range stopTimes {
+		st := &stopTimes[i]
+		// Timepoints may give just one of the two.
+		if st.Arrival == UNTIMED {
+			st.Arrival = st.Departure
+		} else if st.Departure == UNTIMED {
+			st.Departure = st.Arrival
+		}
+		byTrip[st.TripId] = append(byTrip[st.TripId], st)
+		if st.Arrival == UNTIMED {
+			untimed[st.TripId] = true
 		}
 	}
-	return result
-}
+	if len(untimed) == 0 {
+		return stopTimes
+	}
 
-func ReadServiceDates() []ServiceDate {
-	f, err := os.Open("gtfs/calendar.txt")
-	defer f.Close()
-	if err != nil {
-		panic("can't open file...")
+	positions := map[string]fb.Coordinates{}
+	for _, stop := range stops {
+		positions[stop.StopId] = fb.Coordinates{stop.Lat, stop.Long}
 	}
-	reader := csv.NewReader(f)
-
-	result := []ServiceDate{}
-	for i := 0; ; i++ {
-		if i == 0 {
-			// First row, ignore as it's column headers.
-			reader.Read()
-		} else {
-			row := ServiceDate{}
-			if err := Unmarshal(reader, &row); err != "" {
-				if err == "DONE" {
-					break
+	for tripId := range untimed {
+		trip := byTrip[tripId]
+		sort.Slice(trip, func(i, j int) bool { return trip[i].StopSeq < trip[j].StopSeq })
+		// Distance along the trip to each stop.
+		along := make([]float64, len(trip))
+		for i := 1; i < len(trip); i++ {
+			from, to := positions[trip[i-1].StopId], positions[trip[i].StopId]
+			along[i] = along[i-1] + CoordDistKM(&from, &to)
+		}
+		last := -1 // Most recent timed stop.
+		for i, st := range trip {
+			if st.Arrival == UNTIMED {
+				continue
+			}
+			if last != -1 && i-last > 1 {
+				start, end := trip[last].Departure, st.Arrival
+				for j := last + 1; j < i; j++ {
+					fraction := float64(j-last) / float64(i-last)
+					if span := along[i] - along[last]; span > 0 {
+						fraction = (along[j] - along[last]) / span
+					}
+					trip[j].Arrival = start + GTFSTime(fraction*float64(end-start))
+					trip[j].Departure = trip[j].Arrival
 				}
-				panic(err)
 			}
-			result = append(result, row)
+			last = i
 		}
 	}
-	return result
-}
 
-func ReadServiceDateExceptions() []ServiceDateException {
-	f, err := os.Open("gtfs/calendar_dates.txt")
-	defer f.Close()
-	if err != nil {
-		panic("can't open file...")
-	}
-	reader := csv.NewReader(f)
-
-	result := []ServiceDateException{}
-	for i := 0; ; i++ {
-		if i == 0 {
-			// First row, ignore as it's column headers.
-			reader.Read()
-		} else {
-			row := ServiceDateException{}
-			if err := Unmarshal(reader, &row); err != "" {
-				if err == "DONE" {
-					break
-				}
-				panic(err)
-			}
-			result = append(result, row)
+	timed := stopTimes[:0]
+	for _, st := range stopTimes {
+		if st.Arrival != UNTIMED {
+			timed = append(timed, st)
 		}
 	}
-	return result
+	return timed
 }
 
-func ReadRoutes() []Route {
-	f, err := os.Open("gtfs/routes.txt")
-	defer f.Close()
-	if err != nil {
-		panic("can't open file...")
-	}
-	reader := csv.NewReader(f)
-
-	result := []Route{}
-	for i := 0; ; i++ {
-		if i == 0 {
-			// First row, ignore as it's column headers.
-			reader.Read()
-		} else {
-			row := Route{}
-			if err := Unmarshal(reader, &row); err != "" {
-				if err == "DONE" {
-					break
-				}
-				panic(err)
-			}
-			result = append(result, row)
+// Timezones named by stop_timezone, loaded once rather than per request.
+func loadStopLocations(stops []Stop) (map[string]*time.Location, error) {
+	locations := map[string]*time.Location{}
+	for _, stop := range stops {
+		if stop.Timezone == "" || locations[stop.Timezone] != nil {
+			continue
 		}
+		loc, err := time.LoadLocation(stop.Timezone)
+		if err != nil {
+			return nil, &CSVError{"stops.txt", 0, "stop_timezone", err}
+		}
+		locations[stop.Timezone] = loc
 	}
-	return result
+	return locations, nil
 }
 
-func Unmarshal(reader *csv.Reader, v interface{}) string {
-	record, err := reader.Read()
+// readRows decodes every row of a GTFS file into a T.
+func readRows[T any](src FeedSource, file string) ([]T, error) {
+	f, err := src.Open(file)
 	if err != nil {
-		return "DONE"
+		return nil, err
 	}
-	s := reflect.ValueOf(v).Elem()
-	if s.NumField() != len(record) {
-		return "Field mismatch"
+	defer f.Close()
+	decoder, err := NewCSVDecoder(file, f)
+	if err != nil {
+		return nil, err
 	}
-	for i := 0; i < s.NumField(); i++ {
-		f := s.Field(i)
-		switch f.Type().String() {
-		case "string":
-			f.SetString(record[i])
-		case "int":
-			ival, err := strconv.ParseInt(record[i], 10, 0)
-			if err != nil {
-				return "Can't parse int"
-			}
-			f.SetInt(ival)
-		case "float64":
-			fval, err := strconv.ParseFloat(record[i], 64)
-			if err != nil {
-				return "Can't parse float"
-			}
-			f.SetFloat(fval)
-		case "bool":
-			f.SetBool(record[i] == "1")
-		default:
-			return "Unknown type: " + f.Type().String()
+
+	result := []T{}
+	for {
+		var row T
+		if err := decoder.Decode(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
 		}
+		result = append(result, row)
 	}
-	return ""
+	return result, nil
+}
+
+func ReadAgencies(src FeedSource) ([]Agency, error) {
+	return readRows[Agency](src, "agency.txt")
+}
+
+func ReadStops(src FeedSource) ([]Stop, error) {
+	return readRows[Stop](src, "stops.txt")
+}
+
+func ReadStopTimes(src FeedSource) ([]StopTime, error) {
+	return readRows[StopTime](src, "stop_times.txt")
+}
+
+func ReadTrips(src FeedSource) ([]Trip, error) {
+	return readRows[Trip](src, "trips.txt")
+}
+
+func ReadServiceDates(src FeedSource) ([]ServiceDate, error) {
+	return readRows[ServiceDate](src, "calendar.txt")
+}
+
+func ReadServiceDateExceptions(src FeedSource) ([]ServiceDateException, error) {
+	return readRows[ServiceDateException](src, "calendar_dates.txt")
+}
+
+func ReadRoutes(src FeedSource) ([]Route, error) {
+	return readRows[Route](src, "routes.txt")
+}
+
+func ReadTransfers(src FeedSource) ([]Transfer, error) {
+	return readRows[Transfer](src, "transfers.txt")
 }
 
 /*
@@ -0,0 +1,122 @@
+package triptime
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadGTFSInterpolatesBlankTimes(t *testing.T) {
+	d := loadFeed(t, TEST_FEED, memFeed{
+		"stops.txt": TEST_FEED["stops.txt"] +
+			// A quarter of the way from Palo Alto to San Francisco.
+			"m1,Menlo Park Caltrain,37.526412,-122.222256,0,,NB\n",
+		"stop_times.txt": `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+t1,07:00:00,07:00:00,a1,1
+t1,,,m1,2
+t1,07:30:00,07:30:00,b1,3
+t2,08:00:00,,a1,1
+t2,,,b1,2
+`,
+	})
+	tests := []struct {
+		trip string
+		stop string
+		want string // Arrival, departure, or "" if dropped.
+	}{
+		{"t1", "m1", "07:07 07:07"},
+		{"t1", "b1", "07:30 07:30"},
+		{"t2", "a1", "08:00 08:00"},
+		{"t2", "b1", ""},
+	}
+	for _, test := range tests {
+		got := ""
+		if st := d.TimeForStopAndTrip(test.stop, test.trip); st != nil {
+			got = st.Arrival.String() + " " + st.Departure.String()
+		}
+		if got != test.want {
+			t.Errorf("trip %s at %s: got %q, want %q", test.trip, test.stop, got, test.want)
+		}
+	}
+}
+
+func TestLoadGTFSErrors(t *testing.T) {
+	feed := func(replace string, contents string) memFeed {
+		files := memFeed{
+			"agency.txt":     "agency_id,agency_name,agency_url,agency_timezone\nCT,Caltrain,http://caltrain.com,America/Los_Angeles\n",
+			"calendar.txt":   "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nWD,1,1,1,1,1,0,0,20260101,20261231\n",
+			"routes.txt":     "route_id,route_type\nL1,2\n",
+			"stops.txt":      "stop_id,stop_name,stop_lat,stop_lon\na,Palo Alto,37.44,-122.16\n",
+			"trips.txt":      "route_id,service_id,trip_id\nL1,WD,t1\n",
+			"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nt1,07:00:00,07:00:00,a,1\n",
+		}
+		if contents == "" {
+			delete(files, replace)
+		} else {
+			files[replace] = contents
+		}
+		return files
+	}
+	tests := []struct {
+		name     string
+		feed     memFeed
+		wantFile string
+		wantErr  error  // Matched with errors.Is, if set.
+		wantText string // In the error message.
+	}{
+		{"missing file", feed("stops.txt", ""), "stops.txt", os.ErrNotExist, "stops.txt"},
+		{"bad value", feed("stops.txt", "stop_id,stop_name,stop_lat,stop_lon\na,Palo Alto,north,-122.16\n"),
+			"stops.txt", ErrBadValue, "stops.txt:2 [stop_lat]"},
+		{"missing column", feed("trips.txt", "route_id,trip_id\nL1,t1\n"), "trips.txt", ErrMissingColumn, "[service_id]"},
+		{"no agency", feed("agency.txt", "agency_id,agency_name,agency_url,agency_timezone\n"), "agency.txt", nil, "no agency"},
+		{"bad timezone", feed("agency.txt", "agency_id,agency_name,agency_url,agency_timezone\nCT,Caltrain,x,Bay/Area\n"),
+			"agency.txt", nil, "Bay/Area"},
+		{"no calendar at all", feed("calendar.txt", ""), "calendar_dates.txt", os.ErrNotExist, "calendar_dates.txt"},
+	}
+	for _, test := range tests {
+		d, err := LoadGTFS(test.feed)
+		var loadErr *LoadError
+		if d != nil || !errors.As(err, &loadErr) {
+			t.Errorf("%s: got %v, %v, want a LoadError", test.name, d, err)
+			continue
+		}
+		if loadErr.File != test.wantFile {
+			t.Errorf("%s: got file %q, want %q", test.name, loadErr.File, test.wantFile)
+		}
+		if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.wantErr)
+		}
+		if !strings.Contains(err.Error(), test.wantText) {
+			t.Errorf("%s: got %q, want it to mention %q", test.name, err, test.wantText)
+		}
+	}
+
+	if _, err := LoadGTFS(feed("transfers.txt", "")); err != nil {
+		t.Errorf("without the optional transfers.txt: %v", err)
+	}
+}
+
+func TestBoardingTypeUnmarshalCSV(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    BoardingType
+		allowed bool
+	}{
+		{"", BOARDING_REGULAR, true},
+		{"0", BOARDING_REGULAR, true},
+		{"1", BOARDING_NONE, false},
+		{"2", BOARDING_PHONE_AGENCY, true},
+		{"3", BOARDING_ASK_DRIVER, true},
+	}
+	for _, test := range tests {
+		var got BoardingType
+		if err := got.UnmarshalCSV(test.value); err != nil || got != test.want || got.Allowed() != test.allowed {
+			t.Errorf("%q: got %v (allowed %v), %v, want %v (allowed %v)", test.value, got, got.Allowed(), err, test.want, test.allowed)
+		}
+	}
+	var got BoardingType
+	if err := got.UnmarshalCSV("4"); !errors.Is(err, ErrBadValue) {
+		t.Errorf("\"4\": got %v, want ErrBadValue", err)
+	}
+}
@@ -0,0 +1,199 @@
+package triptime
+
+import (
+	"strings"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+// ServiceAlert is a disruption notice from the realtime feed.
+type ServiceAlert struct {
+	Header      string
+	Description string
+	Url         string
+	periods     []alertPeriod
+	selectors   []alertSelector
+}
+
+// When an alert is in force, zero times leaving that end open.
+type alertPeriod struct {
+	start time.Time
+	end   time.Time
+}
+
+// What an alert is about. Every field given has to match, e.g. a route at one stop.
+type alertSelector struct {
+	agencyId string
+	routeId  string
+	stopId   string
+	tripId   string
+}
+
+func (rt *Realtime) addAlert(d *GTFSData, alert *gtfs.Alert, feedName string) {
+	sa := ServiceAlert{
+		Header:      translate(alert.GetHeaderText()),
+		Description: translate(alert.GetDescriptionText()),
+		Url:         translate(alert.GetUrl()),
+	}
+	if sa.Header == "" && sa.Description == "" {
+		return
+	}
+	for _, period := range alert.GetActivePeriod() {
+		p := alertPeriod{}
+		if period.GetStart() != 0 {
+			p.start = time.Unix(int64(period.GetStart()), 0)
+		}
+		if period.GetEnd() != 0 {
+			p.end = time.Unix(int64(period.GetEnd()), 0)
+		}
+		sa.periods = append(sa.periods, p)
+	}
+	for _, entity := range alert.GetInformedEntity() {
+		selector := alertSelector{
+			"",
+			feedId(feedName, entity.GetRouteId()),
+			feedId(feedName, entity.GetStopId()),
+			feedId(feedName, entity.GetTrip().GetTripId()),
+		}
+		if agencyId := entity.GetAgencyId(); agencyId != "" {
+			selector.agencyId = agencyFeedId(feedName, agencyId)
+			if d.index.agencies[selector.agencyId] == nil {
+				// Single-agency feeds may leave agency_id out of agency.txt, but not the alert.
+				selector.agencyId = agencyFeedId(feedName, "")
+			}
+		}
+		if selector.routeId == "" && selector.tripId != "" {
+			if trip := d.GetTrip(selector.tripId); trip != nil {
+				selector.routeId = trip.RouteId
+			}
+		}
+		sa.selectors = append(sa.selectors, selector)
+	}
+	rt.alerts = append(rt.alerts, sa)
+}
+
+// translate picks the English text, or failing that the first given.
+func translate(s *gtfs.TranslatedString) string {
+	text := ""
+	for _, translation := range s.GetTranslation() {
+		lang := strings.ToLower(translation.GetLanguage())
+		if lang == "" || lang == "en" || strings.HasPrefix(lang, "en-") {
+			return strings.TrimSpace(translation.GetText())
+		}
+		if text == "" {
+			text = strings.TrimSpace(translation.GetText())
+		}
+	}
+	return text
+}
+
+// ActiveAt says whether the alert is in force at t. Alerts without periods always are.
+func (a ServiceAlert) ActiveAt(t time.Time) bool {
+	if len(a.periods) == 0 {
+		return true
+	}
+	for _, p := range a.periods {
+		if (p.start.IsZero() || !t.Before(p.start)) && (p.end.IsZero() || t.Before(p.end)) {
+			return true
+		}
+	}
+	return false
+}
+
+// affects says whether the alert applies to a train calling at a stop. Any of
+// the arguments may be "", e.g. to ask about a station rather than one train.
+func (a ServiceAlert) affects(agencyId string, routeId string, stopIds []string, tripId string) bool {
+	for _, s := range a.selectors {
+		if s.agencyId != "" && s.agencyId != agencyId {
+			continue
+		}
+		if s.routeId != "" && s.routeId != routeId {
+			continue
+		}
+		if s.tripId != "" && s.tripId != tripId {
+			continue
+		}
+		if s.stopId != "" && !containsString(stopIds, s.stopId) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// ActiveAlerts lists every alert in force at t.
+func (rt *Realtime) ActiveAlerts(t time.Time) []ServiceAlert {
+	alerts := []ServiceAlert{}
+	if rt == nil {
+		return alerts
+	}
+	for _, alert := range rt.alerts {
+		if alert.ActiveAt(t) {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+// AlertsFor lists the alerts in force at t about a station, or about the trains
+// due there, e.g. a delayed route or a cancelled trip.
+func (d *GTFSData) AlertsFor(station Stop, trips []NextTripResult, t time.Time) []ServiceAlert {
+	// Alerts may name the station or any of its platforms.
+	stationIds := []string{station.StopId, d.StationFor(station).StopId}
+	for _, platform := range d.DirectionalStops(station, "") {
+		stationIds = append(stationIds, platform.StopId)
+	}
+	alerts := []ServiceAlert{}
+	for _, alert := range d.Realtime().ActiveAlerts(t) {
+		relevant := alert.affects("", "", stationIds, "")
+		for _, trip := range trips {
+			if relevant {
+				break
+			}
+			agencyId := ""
+			if agency := d.AgencyFor(trip.Trip.RouteId); agency != nil {
+				agencyId = agency.AgencyId
+			}
+			stopIds := append([]string{trip.Stop.StopId}, stationIds...)
+			relevant = alert.affects(agencyId, trip.Trip.RouteId, stopIds, trip.Trip.TripId)
+		}
+		if relevant {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+// alertSummary is a line per alert's header, truncated so a few alerts don't bury the reply.
+func alertSummary(alerts []ServiceAlert) string {
+	if len(alerts) == 0 {
+		return ""
+	}
+	text := ""
+	for _, alert := range alerts {
+		header := alert.Header
+		if header == "" {
+			header = alert.Description
+		}
+		text += "⚠️ " + truncate(header, 80) + "\n"
+	}
+	return text + "Say 'alerts' for details.\n"
+}
+
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package triptime
+
+import (
+	"fmt"
+
+	ctx "golang.org/x/net/context"
+)
+
+const MAX_ALERTS_TEXT = 2000 // Fits a Messenger message, the shortest limit.
+
+// List every disruption the realtime feed currently reports.
+func alertsAction(c ctx.Context, req Request) Reply {
+	alerts := DATA.Realtime().ActiveAlerts(DATA.Now())
+	text := ""
+	if len(alerts) == 0 {
+		text = "No service alerts right now 👍"
+	}
+	for i, alert := range alerts {
+		entry := "⚠️ " + alert.Header + "\n"
+		if alert.Header == "" {
+			entry = "⚠️ " + truncate(alert.Description, 300) + "\n"
+		} else if alert.Description != "" {
+			entry += truncate(alert.Description, 300) + "\n"
+		}
+		if alert.Url != "" {
+			entry += alert.Url + "\n"
+		}
+		more := fmt.Sprintf("...and %d more.", len(alerts)-i)
+		if len(text)+len(entry)+len(more) > MAX_ALERTS_TEXT {
+			text += more
+			break
+		}
+		text += entry
+	}
+	return textReply(text)
+}
@@ -0,0 +1,21 @@
+package triptime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertsForStationIncludesPlatforms(t *testing.T) {
+	d := loadFeed(t, TEST_FEED, nil)
+	rt := NewRealtime(time.Time{})
+	rt.alerts = append(rt.alerts,
+		ServiceAlert{Header: "SB platform closed", selectors: []alertSelector{{"", "", "a2", ""}}},
+		ServiceAlert{Header: "Elsewhere", selectors: []alertSelector{{"", "", "b1", ""}}})
+	d.SetRealtime(rt)
+
+	station := *d.GetStop("a")
+	alerts := d.AlertsFor(station, nil, time.Time{})
+	if len(alerts) != 1 || alerts[0].Header != "SB platform closed" {
+		t.Errorf("got %+v, want just the SB platform's alert", alerts)
+	}
+}
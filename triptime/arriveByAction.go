@@ -0,0 +1,80 @@
+package triptime
+
+import (
+	"fmt"
+	"strings"
+
+	ctx "golang.org/x/net/context"
+)
+
+func isArriveByRequest(lowerText string) bool {
+	return strings.HasPrefix(lowerText, "arrive ")
+}
+
+// Given a destination and a time to be there, list the latest trains that still make it.
+// Text is "arrive [at] X by TIME", optionally ending "from Y" instead of the user's stop.
+func arriveByAction(c ctx.Context, req Request, lowerText string) Reply {
+	request := strings.TrimPrefix(lowerText, "arrive ")
+	request = strings.TrimPrefix(request, "at ")
+	byAt := strings.LastIndex(request, " by ")
+	if byAt == -1 {
+		return arriveByUsage()
+	}
+	toText, timeText, fromText := request[:byAt], request[byAt+4:], ""
+	if fromAt := strings.Index(timeText, " from "); fromAt != -1 {
+		timeText, fromText = timeText[:fromAt], timeText[fromAt+6:]
+	}
+
+	var from Stop
+	if fromText == "" {
+		var state *UserState
+		var err *Reply
+		if state, err = NeedUserState(c, req); err != nil {
+			return *err
+		}
+		from = state.StopAt
+	} else {
+		var found bool
+		if from, found = DATA.FindStation(fromText); !found {
+			return unknownStationMessage(fromText)
+		}
+	}
+	to, found := DATA.FindStation(toText)
+	if !found {
+		return unknownStationMessage(toText)
+	}
+	if DATA.StationFor(from).StopId == DATA.StationFor(to).StopId {
+		return arriveByUsage()
+	}
+
+	t := DATA.Now()
+	by, ok := parseClockTime(timeText, DATA.LocalTime(to, t))
+	if !ok {
+		return arriveByUsage()
+	}
+	itineraries := DATA.PlanJourneyArriveBy(from, to, t, by)
+
+	text :=
+		fmt.Sprintf("Current time: %s\n", DATA.LocalTime(from, t).Format("15:04")) +
+			fmt.Sprintf("%s → %s by %s:\n", shortStopName(from.Name), shortStopName(to.Name), by.Format("15:04"))
+	if len(itineraries) == 0 {
+		text += "Sorry, there's no train that gets there in time.\n"
+		return textReply(text)
+	}
+
+	reply := Reply{}
+	for _, it := range itineraries {
+		text += formatItinerary(it)
+		first := it.Legs[0]
+		caption := fmt.Sprintf("View %s stops", DATA.LocalTime(first.From, first.Departs).Format("15:04"))
+		reply.AddChoice(caption, listStopsPayload(first.From, first.Trip, first.ServiceDate))
+	}
+	reply.Text = text
+	return reply
+}
+
+func arriveByUsage() Reply {
+	usage := "Try in the form: Arrive [station] by [time]\n"
+	usage += "e.g. Arrive Mountain View by 9am, adding 'from [station]' to start somewhere else"
+	return textReply(usage)
+}
@@ -1,9 +1,10 @@
 package triptime
 
 import (
+	"errors"
 	"math"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/padster/triptime/fb"
@@ -14,234 +15,371 @@ import (
 
 const (
 	RADIUS_KM = 6371.0 // Earth radius in m
+	// How many days ahead to look for the next train once today's have finished.
+	LOOKAHEAD_DAYS = 7
 )
 
 type GTFSData struct {
+	Agencies              []Agency
 	Routes                []Route
 	Stops                 []Stop
 	StopTimes             []StopTime
 	ServiceDates          []ServiceDate
 	ServiceDateExceptions []ServiceDateException
 	Trips                 []Trip
+	Transfers             []Transfer
+
+	// The agency timezone, which all stop times are relative to.
+	Location      *time.Location
+	stopLocations map[string]*time.Location
+	index         scheduleIndex
+
+	// Latest realtime poll, replaced as requests refresh it.
+	realtimeLock sync.RWMutex
+	realtime     *Realtime
+}
+
+// Loaded once per instance. When the feed can't be read DATA stays nil and
+// DATA_ERR says why, so requests can apologise rather than the instance crashing.
+var DATA *GTFSData
+var DATA_ERR error
+
+func init() {
+	DATA, DATA_ERR = LoadFeeds(gtfsFeeds())
+}
+
+// Now is the current time in the feed's timezone.
+func (d *GTFSData) Now() time.Time {
+	return CLOCK.Now().In(d.Location)
 }
 
-var DATA = GTFSData{
-	ReadRoutes(),
-	ReadStops(),
-	ReadStopTimes(),
-	ReadServiceDates(),
-	ReadServiceDateExceptions(),
-	ReadTrips(),
+// LocalTime is t as shown on the clocks at a stop, which only differs from
+// the feed's timezone when stop_timezone (or its station's) says so.
+func (d *GTFSData) LocalTime(stop Stop, t time.Time) time.Time {
+	if stop.Timezone == "" && stop.Parent != "" {
+		if parent := d.GetStop(stop.Parent); parent != nil {
+			stop = *parent
+		}
+	}
+	if loc := d.stopLocations[stop.Timezone]; loc != nil {
+		return t.In(loc)
+	}
+	return t.In(d.Location)
 }
 
 type NextTripResult struct {
-	StopTime *StopTime
-	Stop     Stop
-	Trip     *Trip
+	StopTime    *StopTime
+	Stop        Stop
+	Trip        *Trip
+	ServiceDate time.Time // Day the trip's stop times count from.
+	Prediction  Prediction
+}
+
+// Departs is when the trip leaves Stop, as a real time rather than a GTFS offset,
+// and allowing for any delay the realtime feed reports. Next-train queries are
+// about catching it, so rank and show this rather than when it pulls in.
+func (r NextTripResult) Departs() time.Time {
+	return r.StopTime.Departure.On(r.ServiceDate).Add(r.Prediction.DepartureDelay)
+}
+
+// DepartureText is the scheduled departure, with the predicted one and delay if known.
+func (r NextTripResult) DepartureText() string {
+	return r.Prediction.Describe(r.StopTime.Departure, r.Prediction.DepartureDelay)
+}
+
+// ServiceDay is one date's running trips. Queries look at yesterday's as well
+// as today's, since late trains are still out after midnight.
+type ServiceDay struct {
+	Date     time.Time
+	Services map[string]bool
+	Trips    []*Trip
+}
+
+func (d *GTFSData) serviceDay(date time.Time) ServiceDay {
+	services := d.ActiveServices(date)
+	return ServiceDay{date, services, d.TripsForServices(services)}
+}
+
+// Runs is whether a trip is part of the day's service.
+func (day ServiceDay) Runs(trip *Trip) bool {
+	return trip != nil && day.Services[trip.ServiceId]
+}
+
+// No trains at all run on the requested day, as opposed to just none left.
+var ErrNoService = errors.New("no service today")
+
+// Trains run on the requested day, but the last has already gone.
+var ErrNoMoreTrains = errors.New("no more trains today")
+
+func (d *GTFSData) ServiceDaysAround(t time.Time) []ServiceDay {
+	today := dateOf(t.In(d.Location))
+	days := []ServiceDay{}
+	for _, date := range []time.Time{today.AddDate(0, 0, -1), today} {
+		days = append(days, d.serviceDay(date))
+	}
+	return days
+}
+
+// noServiceError says why nothing was found: ErrNoService if nothing runs today
+// at all, otherwise ErrNoMoreTrains.
+func (d *GTFSData) noServiceError(found int, t time.Time) error {
+	switch {
+	case found > 0:
+		return nil
+	case len(d.ActiveServices(t.In(d.Location))) == 0:
+		return ErrNoService
+	}
+	return ErrNoMoreTrains
 }
 
-func NextTripsAtStop(at Stop) []NextTripResult {
-	t := getSFTime()
-	sId := ServiceIdForToday(t)
-	trips := TripsForServiceId(sId)
+// NextTripsAtStop finds the first train leaving at or after t from each of at's platforms.
+func (d *GTFSData) NextTripsAtStop(at Stop, t time.Time) ([]NextTripResult, error) {
+	days := d.ServiceDaysAround(t)
 
-	allStops := DirectionalStops(at, "")
+	allStops := d.DirectionalStops(at, "")
 	result := []NextTripResult{}
 	for _, stopAt := range allStops {
-		nextStopTime := NextStopTime(stopAt, trips, t)
+		nextStopTime := d.NextStopTime(stopAt, days, t)
 		if nextStopTime.StopTime != nil {
 			result = append(result, nextStopTime)
 		}
 	}
-	return result
+	return result, d.noServiceError(len(result), t)
 }
 
-func NextNTripsFromStop(at Stop, direction string, n int) []NextTripResult {
-	t := getSFTime()
-	sId := ServiceIdForToday(t)
-	trips := TripsForServiceId(sId)
-	allStops := DirectionalStops(at, direction)
+// NextNTripsFromStop finds the n soonest trains leaving at or after t, optionally only those in one direction.
+func (d *GTFSData) NextNTripsFromStop(at Stop, direction string, n int, t time.Time) ([]NextTripResult, error) {
+	days := d.ServiceDaysAround(t)
+	allStops := d.DirectionalStops(at, direction)
+	rt := d.Realtime()
 
 	// insertion sort to find the best N
-	bestTrip := make([]*Trip, n, n)
-	bestStopTime := make([]*StopTime, n, n)
-	stopsAt := make([]Stop, n, n)
+	best := make([]NextTripResult, 0, n+1)
 	for _, stopAt := range allStops {
-		for _, trip := range trips {
-			stopTime := TimeForStopAndTrip(stopAt.StopId, trip.TripId)
-			if stopTime == nil {
-				continue
-			}
-			for i := 0; i < n; i++ {
-				if isBetterStop(stopTime, bestStopTime[i], t) {
-          // Lol, insertion sort.
-					for j := n - 1; j > i; j-- {
-						bestTrip[j] = bestTrip[j-1]
-						bestStopTime[j] = bestStopTime[j-1]
-						stopsAt[j] = stopsAt[j-1]
+		for _, day := range days {
+			for _, stopTime := range d.index.stopTimesByStop[stopAt.StopId] {
+				trip := d.GetTrip(stopTime.TripId)
+				if !day.Runs(trip) || !d.canBoard(stopTime) {
+					continue
+				}
+				curr := NextTripResult{stopTime, stopAt, trip, day.Date, rt.Predict(trip, day.Date, stopTime)}
+				if !isBetterStop(curr, NextTripResult{}, t) {
+					continue
+				}
+				i := len(best)
+				for i > 0 && isBetterStop(curr, best[i-1], t) {
+					i--
+				}
+				if i < n {
+					// Lol, insertion sort.
+					best = append(best, NextTripResult{})
+					copy(best[i+1:], best[i:])
+					best[i] = curr
+					if len(best) > n {
+						best = best[:n]
 					}
-					bestTrip[i] = trip
-					bestStopTime[i] = stopTime
-					stopsAt[i] = stopAt
-					break
 				}
 			}
 		}
 	}
+	return best, d.noServiceError(len(best), t)
+}
 
-	resultCount := 0
-	for resultCount < n && bestTrip[resultCount] != nil {
-		resultCount++
-	}
-	result := make([]NextTripResult, resultCount, resultCount)
-	for i := 0; i < resultCount; i++ {
-		result[i] = NextTripResult{
-			bestStopTime[i], stopsAt[i], bestTrip[i],
+func (d *GTFSData) NextStopTime(stopAt Stop, days []ServiceDay, t time.Time) NextTripResult {
+	best := NextTripResult{Stop: stopAt}
+	rt := d.Realtime()
+	for _, day := range days {
+		for _, stopTime := range d.index.stopTimesByStop[stopAt.StopId] {
+			trip := d.GetTrip(stopTime.TripId)
+			if !day.Runs(trip) || !d.canBoard(stopTime) {
+				continue
+			}
+			curr := NextTripResult{stopTime, stopAt, trip, day.Date, rt.Predict(trip, day.Date, stopTime)}
+			if isBetterStop(curr, best, t) {
+				best = curr
+			}
 		}
 	}
-	return result
+	return best
 }
 
-func NextStopTime(stopAt Stop, trips []*Trip, t time.Time) NextTripResult {
-	var bestTrip *Trip
-	var bestStopTime *StopTime
-
-	// var bestStopTime *StopTime
-	for _, trip := range trips {
-		stopTime := TimeForStopAndTrip(stopAt.StopId, trip.TripId)
-		if isBetterStop(stopTime, bestStopTime, t) {
-			bestTrip = trip
-			bestStopTime = stopTime
+// FirstTripOfNextServiceDay finds the first train at a station on the next day after
+// t's that has one, looking up to LOOKAHEAD_DAYS ahead, for when today's have all gone.
+func (d *GTFSData) FirstTripOfNextServiceDay(at Stop, direction string, t time.Time) (NextTripResult, bool) {
+	today := dateOf(t.In(d.Location))
+	platforms := d.DirectionalStops(at, direction)
+	for i := 1; i <= LOOKAHEAD_DAYS; i++ {
+		date := today.AddDate(0, 0, i)
+		days := []ServiceDay{d.serviceDay(date)}
+		best := NextTripResult{}
+		for _, stopAt := range platforms {
+			if curr := d.NextStopTime(stopAt, days, serviceDayStart(date)); isBetterStop(curr, best, t) {
+				best = curr
+			}
+		}
+		if best.StopTime != nil {
+			return best, true
 		}
-
-	}
-	return NextTripResult{
-		bestStopTime, stopAt, bestTrip,
 	}
+	return NextTripResult{}, false
 }
 
-func ServiceIdForToday(t time.Time) string {
-	asDate := dateAsString(t)
-	for _, ex := range DATA.ServiceDateExceptions {
-		if ex.ExceptionType == 1 && ex.Date == asDate {
-			return ex.ServiceId
-		}
-	}
-	day := t.Format("Mon")
-	for _, sd := range DATA.ServiceDates {
-		switch day {
-		case "Mon":
-			if sd.OnMon {
-				return sd.ServiceId
-			}
-		case "Tue":
-			if sd.OnTue {
-				return sd.ServiceId
-			}
-		case "Wed":
-			if sd.OnWed {
-				return sd.ServiceId
-			}
-		case "Thu":
-			if sd.OnThu {
-				return sd.ServiceId
-			}
-		case "Fri":
-			if sd.OnFri {
-				return sd.ServiceId
-			}
-		case "Sat":
-			if sd.OnSat {
-				return sd.ServiceId
-			}
-		case "Sun":
-			if sd.OnSun {
-				return sd.ServiceId
-			}
-		}
+// canBoard says whether there's a train to catch at a stop: it has to pick
+// passengers up there, and not be at the end of its trip.
+func (d *GTFSData) canBoard(stopTime *StopTime) bool {
+	if !stopTime.PickupType.Allowed() {
+		return false
 	}
-	panic("No service for " + asDate + "!")
+	stopTimes := d.index.stopTimesByTrip[stopTime.TripId]
+	return len(stopTimes) == 0 || stopTimes[len(stopTimes)-1].StopSeq != stopTime.StopSeq
 }
 
-func TripsForServiceId(id string) []*Trip {
-	// TODO - index?
+func (d *GTFSData) TripsForServiceId(id string) []*Trip {
+	return d.index.tripsByService[id]
+}
+
+// TripsForServices lists the trips of every given service, e.g. a base timetable plus an event overlay.
+func (d *GTFSData) TripsForServices(services map[string]bool) []*Trip {
 	trips := []*Trip{}
-	for i, trip := range DATA.Trips {
-		if trip.ServiceId == id {
-			trips = append(trips, &DATA.Trips[i])
-		}
+	for sId := range services {
+		trips = append(trips, d.TripsForServiceId(sId)...)
 	}
 	return trips
 }
 
-func ClosestStop(c ctx.Context, at *fb.Coordinates) Stop {
-	bestStop := -1
+// ClosestStop finds the nearest station (or standalone stop), rather than one of its platforms.
+// It reports false if the feed has none.
+func (d *GTFSData) ClosestStop(c ctx.Context, at *fb.Coordinates) (Stop, bool) {
+	var best *Stop
 	bestDist := 0.0
-	for i, stop := range DATA.Stops {
-    // Note: all stops here are now directional :( Fixed up by calling DirectionalStops
+	for i, stop := range d.Stops {
+		if !stop.IsStation() {
+			continue
+		}
 		stopAt := fb.Coordinates{stop.Lat, stop.Long}
 		dist := CoordDistKM(at, &stopAt)
-		if bestStop == -1 || dist < bestDist {
-			bestStop = i
+		if best == nil || dist < bestDist {
+			best = &d.Stops[i]
 			bestDist = dist
 		}
 	}
-	return DATA.Stops[bestStop]
+	if best == nil {
+		return Stop{}, false
+	}
+	return *best, true
 }
 
-func DirectionalStops(stop Stop, direction string) []Stop {
+// DirectionalStops finds the platforms trains call at for a station, optionally
+// only those with the given platform code (e.g. NB/SB).
+func (d *GTFSData) DirectionalStops(stop Stop, direction string) []Stop {
+	station := d.StationFor(stop)
+	platforms := d.index.platformsByStation[station.StopId]
+	if len(platforms) == 0 {
+		// Standalone stop, trains call at the stop itself.
+		platforms = []*Stop{&station}
+	}
+
 	stops := []Stop{}
-	for _, child := range DATA.Stops {
-		if child.Type == 0 && child.Name == stop.Name { // Name comparison
-			if direction == "" || direction == child.PlatCode {
-				stops = append(stops, child)
-			}
+	for _, platform := range platforms {
+		if direction == "" || direction == platform.PlatCode {
+			stops = append(stops, *platform)
 		}
 	}
 	return stops
 }
 
-func TimeForStopAndTrip(stopId string, tripId string) *StopTime {
-	// TODO - index
-	for _, stopTime := range DATA.StopTimes {
-		if stopTime.TripId == tripId && stopTime.StopId == stopId {
-			return &stopTime
+// FindStation matches typed text against station names, preferring an exact
+// match, then the start of a name, then anywhere in it.
+func (d *GTFSData) FindStation(text string) (Stop, bool) {
+	query := normalizeStationName(text)
+	if query == "" {
+		return Stop{}, false
+	}
+	var prefix, contains *Stop
+	for i, stop := range d.Stops {
+		if !stop.IsStation() {
+			continue
+		}
+		name := normalizeStationName(stop.Name)
+		switch {
+		case name == query:
+			return stop, true
+		case prefix == nil && strings.HasPrefix(name, query):
+			prefix = &d.Stops[i]
+		case contains == nil && strings.Contains(name, query):
+			contains = &d.Stops[i]
 		}
 	}
-	return nil
+	if prefix != nil {
+		return *prefix, true
+	}
+	if contains != nil {
+		return *contains, true
+	}
+	return Stop{}, false
 }
 
-func SortedStopTimesForTrip(tripId string) []StopTime {
-	result := []StopTime{}
-	for _, stopTime := range DATA.StopTimes {
-		if stopTime.TripId == tripId {
-			result = append(result, stopTime)
-		}
+func normalizeStationName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, suffix := range []string{" caltrain station", " caltrain", " station"} {
+		name = strings.TrimSuffix(name, suffix)
 	}
-	sort.Sort(TimesByTime(result))
-	return result
+	return strings.TrimSpace(name)
 }
 
-func GetStop(stopId string) *Stop {
-	// TODO - index
-	for _, stop := range DATA.Stops {
-		if stop.StopId == stopId {
-			return &stop
+// StationFor is the station a platform belongs to, or the stop itself if it has no parent.
+func (d *GTFSData) StationFor(stop Stop) Stop {
+	if stop.Parent != "" {
+		if parent := d.GetStop(stop.Parent); parent != nil {
+			return *parent
 		}
 	}
-	return nil
+	return stop
 }
 
-func GetRoute(routeId string) *Route {
-	// TODO - index
-	for _, route := range DATA.Routes {
-		if route.RouteId == routeId {
-			return &route
+func (d *GTFSData) TimeForStopAndTrip(stopId string, tripId string) *StopTime {
+	stopTimes := d.index.stopTimesByTrip[tripId]
+	for i := range stopTimes {
+		if stopTimes[i].StopId == stopId {
+			return &stopTimes[i]
 		}
 	}
 	return nil
 }
 
+// SortedStopTimesForTrip lists a trip's stops in stop_sequence order, callers mustn't modify it.
+func (d *GTFSData) SortedStopTimesForTrip(tripId string) []StopTime {
+	return d.index.stopTimesByTrip[tripId]
+}
+
+func (d *GTFSData) GetStop(stopId string) *Stop {
+	return d.index.stops[stopId]
+}
+
+func (d *GTFSData) GetRoute(routeId string) *Route {
+	return d.index.routes[routeId]
+}
+
+// RouteName is the long (or failing that, short) name for display, or "" for a route the feed doesn't list.
+func (d *GTFSData) RouteName(routeId string) string {
+	route := d.GetRoute(routeId)
+	if route == nil {
+		return ""
+	}
+	if route.LongName == "" {
+		return route.ShortName
+	}
+	return route.LongName
+}
+
+// DirectionName says which way a train is going, by platform code (NB/SB) where the feed has them.
+func DirectionName(stop Stop, trip *Trip) string {
+	if stop.PlatCode != "" || trip.HeadSign == "" {
+		return stop.PlatCode
+	}
+	return "to " + trip.HeadSign
+}
+
 // http://stackoverflow.com/questions/27928/calculate-distance-between-two-latitude-longitude-points-haversine-formula
 func CoordDistKM(a *fb.Coordinates, b *fb.Coordinates) float64 {
 	dLat := deg2rad(b.Lat - a.Lat)
@@ -257,44 +395,18 @@ func deg2rad(deg float64) float64 {
 	return deg * math.Pi / 180.0
 }
 
-func isBetterStop(curr *StopTime, best *StopTime, t time.Time) bool {
-	if curr == nil {
+// isBetterStop says whether curr still leaves at or after t, and sooner than best (if set).
+// Cancelled trains never are.
+func isBetterStop(curr NextTripResult, best NextTripResult, t time.Time) bool {
+	if curr.StopTime == nil || curr.Prediction.Cancelled {
 		return false
 	}
-	untilCurr := secondsUntil(t, curr.Arrival)
-	if untilCurr < 0 {
+	currAt := curr.Departs()
+	if currAt.Before(t) {
 		return false
 	}
-
-	if best == nil {
-		return true
-	}
-	untilBest := secondsUntil(t, best.Arrival)
-	if untilBest < 0 {
+	if best.StopTime == nil {
 		return true
 	}
-
-	return untilCurr < untilBest
-}
-
-func normalizeTime(timeFmt string) string {
-	// H:MM:SS -> HH:MM:SS
-	if len(timeFmt) == 7 {
-		return "0" + timeFmt
-	} else {
-		return timeFmt
-	}
-}
-
-/// Grr, Go
-type TimesByTime []StopTime
-
-func (times TimesByTime) Len() int {
-	return len(times)
-}
-func (times TimesByTime) Swap(i, j int) {
-	times[i], times[j] = times[j], times[i]
-}
-func (times TimesByTime) Less(i, j int) bool {
-	return strings.Compare(normalizeTime(times[i].Arrival), normalizeTime(times[j].Arrival)) < 0
+	return currAt.Before(best.Departs())
 }
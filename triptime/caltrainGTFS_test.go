@@ -0,0 +1,402 @@
+package triptime
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/padster/triptime/fb"
+)
+
+// Two stations with NB and SB platforms. Weekdays have a train after midnight,
+// Saturdays and Sundays a single morning train.
+var TEST_FEED = memFeed{
+	"agency.txt": `agency_id,agency_name,agency_url,agency_timezone
+CT,Caltrain,http://caltrain.com,America/Los_Angeles
+`,
+	"calendar.txt": `service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date
+WD,1,1,1,1,1,0,0,20260101,20261231
+SA,0,0,0,0,0,1,0,20260101,20261231
+SU,0,0,0,0,0,0,1,20260101,20261231
+`,
+	"routes.txt": `route_id,route_long_name,route_type
+L1,Local,2
+`,
+	"stops.txt": `stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station,platform_code
+a,Palo Alto Caltrain,37.443070,-122.164900,1,,
+a1,Palo Alto Caltrain,37.443070,-122.164900,0,a,NB
+a2,Palo Alto Caltrain,37.443070,-122.164900,0,a,SB
+b,San Francisco Caltrain,37.776439,-122.394323,1,,
+b1,San Francisco Caltrain,37.776439,-122.394323,0,b,NB
+b2,San Francisco Caltrain,37.776439,-122.394323,0,b,SB
+`,
+	"trips.txt": `route_id,service_id,trip_id,trip_short_name
+L1,WD,t1,101
+L1,WD,t2,103
+L1,WD,late,199
+L1,WD,s1,102
+L1,SA,sat1,401
+L1,SU,sun1,801
+`,
+	"stop_times.txt": `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+t1,07:00:00,07:00:00,a1,1
+t1,07:30:00,07:30:00,b1,2
+t2,08:00:00,08:00:00,a1,1
+t2,08:30:00,08:30:00,b1,2
+late,24:20:00,24:20:00,a1,1
+late,24:50:00,24:50:00,b1,2
+s1,07:10:00,07:10:00,b2,1
+s1,07:40:00,07:40:00,a2,2
+sat1,07:00:00,07:00:00,a1,1
+sat1,07:30:00,07:30:00,b1,2
+sun1,07:00:00,07:00:00,a1,1
+sun1,07:30:00,07:30:00,b1,2
+`,
+}
+
+// loadFeed loads a test feed, with files replaced or added by overrides.
+func loadFeed(t testing.TB, base memFeed, overrides memFeed) *GTFSData {
+	files := memFeed{}
+	for name, contents := range base {
+		files[name] = contents
+	}
+	for name, contents := range overrides {
+		files[name] = contents
+	}
+	d, err := LoadGTFS(files)
+	if err != nil {
+		t.Fatalf("LoadGTFS: %v", err)
+	}
+	return d
+}
+
+// at is a wall clock time in the test feed's timezone, e.g. "2026-10-21 07:00".
+func at(t testing.TB, d *GTFSData, value string) time.Time {
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, d.Location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestGTFSTimeOn(t *testing.T) {
+	d := loadFeed(t, TEST_FEED, nil)
+	tests := []struct {
+		name        string
+		serviceDate string
+		time        string
+		want        string
+	}{
+		{"ordinary day", "2026-10-21", "07:00:00", "2026-10-21 07:00 PDT"},
+		{"past midnight", "2026-10-21", "25:10:00", "2026-10-22 01:10 PDT"},
+		// Times count from noon minus 12h, so the hour lost or gained is before 01:00.
+		{"DST starts", "2026-03-08", "07:00:00", "2026-03-08 07:00 PDT"},
+		{"DST starts, before the change", "2026-03-08", "01:00:00", "2026-03-08 00:00 PST"},
+		{"DST ends", "2026-11-01", "07:00:00", "2026-11-01 07:00 PST"},
+		{"DST ends, before the change", "2026-11-01", "00:30:00", "2026-11-01 01:30 PDT"},
+	}
+	for _, test := range tests {
+		gtfsTime, err := ParseGTFSTime(test.time)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		date := dateOf(at(t, d, test.serviceDate+" 12:00"))
+		if got := gtfsTime.On(date).Format("2006-01-02 15:04 MST"); got != test.want {
+			t.Errorf("%s: %s on %s is %s, want %s", test.name, test.time, test.serviceDate, got, test.want)
+		}
+	}
+}
+
+func TestNextTrips(t *testing.T) {
+	d := loadFeed(t, TEST_FEED, nil)
+	station := *d.GetStop("a")
+	defer func() { CLOCK = systemClock{} }()
+
+	tests := []struct {
+		name    string
+		now     string
+		want    []string // Departures, as local date and time.
+		wantErr error
+	}{
+		{"before the first train", "2026-10-21 06:50", []string{"2026-10-21 07:00", "2026-10-21 08:00"}, nil},
+		{"just missed one", "2026-10-21 07:01", []string{"2026-10-21 08:00", "2026-10-22 00:20"}, nil},
+		{"yesterday's train after midnight", "2026-10-22 00:10", []string{"2026-10-22 00:20", "2026-10-22 07:00"}, nil},
+		{"last train of the day", "2026-10-24 07:00", []string{"2026-10-24 07:00"}, nil},
+		{"after the last train", "2026-10-24 07:01", nil, ErrNoMoreTrains},
+		{"DST starts", "2026-03-08 06:50", []string{"2026-03-08 07:00"}, nil},
+		{"DST ends", "2026-11-01 06:50", []string{"2026-11-01 07:00"}, nil},
+	}
+	for _, test := range tests {
+		CLOCK = FixedClock(at(t, d, test.now))
+		results, err := d.NextNTripsFromStop(station, "NB", 2, d.Now())
+		if err != test.wantErr {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.wantErr)
+		}
+		got := []string{}
+		for _, result := range results {
+			got = append(got, d.LocalTime(result.Stop, result.Departs()).Format("2006-01-02 15:04"))
+		}
+		if strings.Join(got, ", ") != strings.Join(test.want, ", ") {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFirstTripOfNextServiceDay(t *testing.T) {
+	d := loadFeed(t, TEST_FEED, nil)
+	station := *d.GetStop("a")
+	tests := []struct {
+		name      string
+		now       string
+		direction string
+		want      string
+		found     bool
+	}{
+		{"Saturday's gone", "2026-10-24 08:00", "NB", "2026-10-25 07:00 801", true},
+		{"Friday night", "2026-10-23 23:00", "", "2026-10-24 07:00 401", true},
+		{"nothing ever runs SB", "2026-10-24 08:00", "SB", "", false},
+	}
+	for _, test := range tests {
+		first, found := d.FirstTripOfNextServiceDay(station, test.direction, at(t, d, test.now))
+		got := ""
+		if found {
+			got = d.LocalTime(first.Stop, first.Departs()).Format("2006-01-02 15:04") + " " + first.Trip.ShortName
+		}
+		if found != test.found || got != test.want {
+			t.Errorf("%s: got %q (%v), want %q (%v)", test.name, got, found, test.want, test.found)
+		}
+	}
+}
+
+func TestNoServiceIsAnAnswer(t *testing.T) {
+	// Weekdays only, one train.
+	d, err := LoadGTFS(memFeed{
+		"agency.txt":     "agency_id,agency_name,agency_url,agency_timezone\nCT,Caltrain,http://caltrain.com,America/Los_Angeles\n",
+		"calendar.txt":   "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nWD,1,1,1,1,1,0,0,20260101,20261231\n",
+		"routes.txt":     "route_id,route_type\nL1,2\n",
+		"stops.txt":      "stop_id,stop_name,stop_lat,stop_lon\na,Palo Alto,37.44,-122.16\nb,San Francisco,37.77,-122.39\n",
+		"trips.txt":      "route_id,service_id,trip_id,trip_headsign\nL1,WD,t1,San Francisco\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nt1,07:00:00,07:00:00,a,1\nt1,07:30:00,07:30:00,b,2\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := DATA
+	DATA = d
+	defer func() { DATA = saved }()
+
+	stop := *d.GetStop("a")
+	tests := []struct {
+		name        string
+		now         string
+		wantErr     error
+		wantMessage string
+	}{
+		{"weekday morning", "2026-10-23 06:00", nil, ""},
+		{"weekday evening", "2026-10-23 18:00", ErrNoMoreTrains,
+			"No more trains today, the first train on Monday is at 07:00 (to San Francisco).\n"},
+		{"weekend", "2026-10-24 08:00", ErrNoService,
+			"There are no trains running today, the first train on Monday is at 07:00 (to San Francisco).\n"},
+		{"Sunday", "2026-10-25 08:00", ErrNoService,
+			"There are no trains running today, the first train tomorrow is at 07:00 (to San Francisco).\n"},
+		{"after the calendar ends", "2027-01-04 08:00", ErrNoService, "There are no trains running today.\n"},
+	}
+	for _, test := range tests {
+		now := at(t, d, test.now)
+		if _, err := d.NextTripsAtStop(stop, now); err != test.wantErr {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.wantErr)
+		}
+		_, err := d.NextNTripsFromStop(stop, "", 2, now)
+		if got := noMoreTrainsMessage(err, stop, "", now); got != test.wantMessage {
+			t.Errorf("%s: got message %q, want %q", test.name, got, test.wantMessage)
+		}
+	}
+}
+
+func TestClosestStop(t *testing.T) {
+	paloAlto := &fb.Coordinates{37.4431, -122.1649}
+	tests := []struct {
+		name  string
+		stops []Stop
+		want  string
+		found bool
+	}{
+		{"no stops", nil, "", false},
+		{"only platforms", []Stop{
+			{StopId: "a1", Lat: 37.4431, Long: -122.1649, Type: LOCATION_STOP, Parent: "a"},
+		}, "", false},
+		{"station rather than its platform", []Stop{
+			{StopId: "a1", Lat: 37.4431, Long: -122.1649, Type: LOCATION_STOP, Parent: "a"},
+			{StopId: "a", Lat: 37.4432, Long: -122.1649, Type: LOCATION_STATION},
+			{StopId: "b", Lat: 37.7764, Long: -122.3943, Type: LOCATION_STATION},
+		}, "a", true},
+		{"standalone stop", []Stop{
+			{StopId: "b", Lat: 37.7764, Long: -122.3943, Type: LOCATION_STATION},
+			{StopId: "x", Lat: 37.4500, Long: -122.1700, Type: LOCATION_STOP},
+		}, "x", true},
+	}
+	for _, test := range tests {
+		d := &GTFSData{Stops: test.stops}
+		d.buildIndex()
+		stop, found := d.ClosestStop(nil, paloAlto)
+		if found != test.found || stop.StopId != test.want {
+			t.Errorf("%s: got %q (%v), want %q (%v)", test.name, stop.StopId, found, test.want, test.found)
+		}
+	}
+}
+
+func TestFeedTimezone(t *testing.T) {
+	// Times are New York's, but the station out west keeps Chicago's clocks.
+	feed := memFeed{
+		"agency.txt":   "agency_id,agency_name,agency_url,agency_timezone\nAM,Amtrak,http://amtrak.com,America/New_York\n",
+		"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nD,1,1,1,1,1,1,1,20260101,20261231\n",
+		"routes.txt":   "route_id,route_type\nLS,2\n",
+		"stops.txt": "stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station,stop_timezone\n" +
+			"nyp,New York,40.75,-73.99,1,,\nnyp1,New York,40.75,-73.99,0,nyp,\n" +
+			"chi,Chicago,41.87,-87.63,1,,America/Chicago\nchi1,Chicago,41.87,-87.63,0,chi,\n",
+		"trips.txt":      "route_id,service_id,trip_id\nLS,D,49\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n49,15:40:00,15:40:00,nyp1,1\n49,33:45:00,33:45:00,chi1,2\n",
+	}
+	d, err := LoadGTFS(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Location.String() != "America/New_York" {
+		t.Errorf("got timezone %v, want the agency's", d.Location)
+	}
+
+	// Half past three in New York is half past two in Chicago.
+	now := time.Date(2026, 10, 21, 19, 30, 0, 0, time.UTC)
+	if got := d.LocalTime(*d.GetStop("nyp"), now).Format("15:04 MST"); got != "15:30 EDT" {
+		t.Errorf("New York clock: got %s, want 15:30 EDT", got)
+	}
+	if got := d.LocalTime(*d.GetStop("chi1"), now).Format("15:04 MST"); got != "14:30 CDT" {
+		t.Errorf("Chicago platform clock: got %s, want its station's 14:30 CDT", got)
+	}
+
+	// Departures count from New York's service day, arrivals show on Chicago's clocks.
+	trips, err := d.NextTripsAtStop(*d.GetStop("nyp"), now)
+	if err != nil || len(trips) != 1 {
+		t.Fatalf("got %v, %v, want train 49", trips, err)
+	}
+	if got := d.LocalTime(trips[0].Stop, trips[0].Departs()).Format("Mon 15:04 MST"); got != "Wed 15:40 EDT" {
+		t.Errorf("departs %s, want Wed 15:40 EDT", got)
+	}
+	arrival := d.TimeForStopAndTrip("chi1", "49").Arrival.On(trips[0].ServiceDate)
+	if got := d.LocalTime(*d.GetStop("chi1"), arrival).Format("Mon 15:04 MST"); got != "Thu 08:45 CDT" {
+		t.Errorf("arrives %s, want Thu 08:45 CDT", got)
+	}
+
+	feed["stops.txt"] += "den,Denver,39.75,-105.0,1,,Mountain/Time\n"
+	if _, err := LoadGTFS(feed); err == nil || !strings.Contains(err.Error(), "Mountain/Time") {
+		t.Errorf("got %v, want an error about the unknown stop_timezone", err)
+	}
+}
+
+func TestDirectionalStops(t *testing.T) {
+	d := &GTFSData{Stops: []Stop{
+		// Two stations sharing a name, one with platforms named differently.
+		{StopId: "sc", Name: "San Carlos", Type: LOCATION_STATION},
+		{StopId: "sc1", Name: "San Carlos Northbound", Type: LOCATION_STOP, Parent: "sc", PlatCode: "NB"},
+		{StopId: "sc2", Name: "San Carlos Southbound", Type: LOCATION_STOP, Parent: "sc", PlatCode: "SB"},
+		{StopId: "lr", Name: "San Carlos", Type: LOCATION_STATION},
+		{StopId: "lr1", Name: "San Carlos", Type: LOCATION_STOP, Parent: "lr"},
+		// Not part of a station.
+		{StopId: "bus", Name: "San Carlos", Type: LOCATION_STOP},
+	}}
+	d.buildIndex()
+
+	tests := []struct {
+		name      string
+		stop      string
+		direction string
+		want      string // Platform IDs.
+	}{
+		{"station", "sc", "", "sc1,sc2"},
+		{"one direction", "sc", "SB", "sc2"},
+		{"no such direction", "sc", "EB", ""},
+		{"from a platform", "sc1", "", "sc1,sc2"},
+		{"same name, other station", "lr", "", "lr1"},
+		{"standalone stop", "bus", "", "bus"},
+	}
+	for _, test := range tests {
+		got := []string{}
+		for _, platform := range d.DirectionalStops(*d.GetStop(test.stop), test.direction) {
+			got = append(got, platform.StopId)
+		}
+		if strings.Join(got, ",") != test.want {
+			t.Errorf("%s: got %v, want %s", test.name, got, test.want)
+		}
+	}
+
+	for stop, want := range map[string]string{"sc2": "sc", "lr1": "lr", "sc": "sc", "bus": "bus"} {
+		if got := d.StationFor(*d.GetStop(stop)).StopId; got != want {
+			t.Errorf("StationFor(%s): got %s, want %s", stop, got, want)
+		}
+	}
+}
+
+func TestNextTripsHonourPickupType(t *testing.T) {
+	// The 07:00 only lets passengers off at Palo Alto, and the 07:30 ends there.
+	d, err := LoadGTFS(memFeed{
+		"agency.txt":   "agency_id,agency_name,agency_url,agency_timezone\nCT,Caltrain,http://caltrain.com,America/Los_Angeles\n",
+		"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nD,1,1,1,1,1,1,1,20260101,20261231\n",
+		"routes.txt":   "route_id,route_type\nL1,2\n",
+		"stops.txt":    "stop_id,stop_name,stop_lat,stop_lon\nsj,San Jose,37.33,-121.90\npa,Palo Alto,37.44,-122.16\nsf,San Francisco,37.77,-122.39\n",
+		"trips.txt":    "route_id,service_id,trip_id\nL1,D,dropoff\nL1,D,ends\nL1,D,regular\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence,pickup_type,drop_off_type\n" +
+			"dropoff,06:40:00,06:40:00,sj,1,0,1\ndropoff,07:00:00,07:00:00,pa,2,1,0\ndropoff,07:30:00,07:30:00,sf,3,1,0\n" +
+			"ends,07:10:00,07:10:00,sj,1,,\nends,07:30:00,07:30:00,pa,2,,\n" +
+			"regular,07:40:00,07:40:00,pa,1,,\nregular,08:10:00,08:10:00,sf,2,,\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := at(t, d, "2026-10-21 06:50")
+	trips, err := d.NextNTripsFromStop(*d.GetStop("pa"), "", 3, now)
+	got := []string{}
+	for _, trip := range trips {
+		got = append(got, trip.Trip.TripId)
+	}
+	if err != nil || strings.Join(got, ",") != "regular" {
+		t.Errorf("next trains: got %v, %v, want just the regular one", got, err)
+	}
+	if next, _ := d.NextTripsAtStop(*d.GetStop("pa"), now); len(next) != 1 || next[0].Trip.TripId != "regular" {
+		t.Errorf("next train: got %+v, want the regular one", next)
+	}
+}
+
+func TestNextTripsByDeparture(t *testing.T) {
+	// The local waits at Palo Alto for the express to overtake it.
+	d, err := LoadGTFS(memFeed{
+		"agency.txt":   "agency_id,agency_name,agency_url,agency_timezone\nCT,Caltrain,http://caltrain.com,America/Los_Angeles\n",
+		"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nD,1,1,1,1,1,1,1,20260101,20261231\n",
+		"routes.txt":   "route_id,route_type\nL1,2\n",
+		"stops.txt":    "stop_id,stop_name,stop_lat,stop_lon\nsj,San Jose,37.33,-121.90\npa,Palo Alto,37.44,-122.16\nsf,San Francisco,37.77,-122.39\n",
+		"trips.txt":    "route_id,service_id,trip_id\nL1,D,local\nL1,D,express\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"local,06:30:00,06:30:00,sj,1\nlocal,07:00:00,07:12:00,pa,2\nlocal,07:50:00,07:50:00,sf,3\n" +
+			"express,06:45:00,06:45:00,sj,1\nexpress,07:05:00,07:06:00,pa,2\nexpress,07:35:00,07:35:00,sf,3\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		now  string
+		want string // Trips, with when they leave.
+	}{
+		{"both to come", "2026-10-21 06:50", "express 07:06, local 07:12"},
+		{"local in the platform", "2026-10-21 07:07", "local 07:12"},
+	}
+	for _, test := range tests {
+		trips, _ := d.NextNTripsFromStop(*d.GetStop("pa"), "", 2, at(t, d, test.now))
+		got := []string{}
+		for _, trip := range trips {
+			got = append(got, trip.Trip.TripId+" "+trip.DepartureText())
+		}
+		if strings.Join(got, ", ") != test.want {
+			t.Errorf("%s: got %v, want %s", test.name, got, test.want)
+		}
+	}
+}
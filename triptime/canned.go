@@ -1,21 +1,17 @@
 package triptime
 
 import (
-	"github.com/padster/triptime/fb"
-
 	ctx "golang.org/x/net/context"
 )
 
 // A simplified response for when the user replies with text we don't know.
-func cannedResponseAction(c ctx.Context, msg fb.Message, lowerText string) *fb.OutboundMessage {
+func cannedResponseAction(c ctx.Context, req Request, lowerText string) *Reply {
 	response := cannedResponses(lowerText)
 	if response == "" {
 		return nil
 	}
-	return &fb.OutboundMessage{
-		msg.Sender,
-		outMessageDataFromText(response),
-	}
+	reply := textReply(response)
+	return &reply
 }
 
 func cannedResponses(msg string) string {
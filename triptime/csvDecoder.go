@@ -0,0 +1,194 @@
+package triptime
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrMissingColumn = errors.New("missing required column")
+	ErrBadValue      = errors.New("can't parse value")
+)
+
+// CSVError describes a problem decoding one GTFS file, with as much location as is known.
+type CSVError struct {
+	File   string
+	Line   int    // 1-based line of the file, 0 if not known.
+	Column string // GTFS column name, "" if not column specific.
+	Err    error
+}
+
+func (e *CSVError) Error() string {
+	where := e.File
+	if e.Line > 0 {
+		where += fmt.Sprintf(":%d", e.Line)
+	}
+	if e.Column != "" {
+		where += fmt.Sprintf(" [%s]", e.Column)
+	}
+	return fmt.Sprintf("%s: %v", where, e.Err)
+}
+
+func (e *CSVError) Unwrap() error {
+	return e.Err
+}
+
+// CSVDecoder reads GTFS rows into structs, matching columns to fields by the
+// `csv:"column_name"` tag rather than by position. Columns the struct doesn't
+// know about are skipped, and fields tagged `csv:"name,optional"` fall back to
+// their `default:"..."` tag (or zero value) when the column or cell is absent.
+type CSVDecoder struct {
+	file    string
+	reader  *csv.Reader
+	columns map[string]int
+
+	planType reflect.Type
+	plan     []fieldPlan
+}
+
+type fieldPlan struct {
+	index    int // Struct field index.
+	column   string
+	position int // Column position in the file, -1 if missing.
+	fallback string
+}
+
+// NewCSVDecoder reads the header row of r, file is only used for error reporting.
+func NewCSVDecoder(file string, r io.Reader) (*CSVDecoder, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, &CSVError{file, 1, "", errors.New("missing header row")}
+	} else if err != nil {
+		return nil, &CSVError{file, 1, "", err}
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		if i == 0 {
+			// Some agencies export with a UTF-8 byte order mark.
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		columns[strings.TrimSpace(name)] = i
+	}
+	return &CSVDecoder{
+		file:    file,
+		reader:  reader,
+		columns: columns,
+	}, nil
+}
+
+// Decode fills v (a pointer to a tagged struct) from the next row, returning io.EOF when done.
+func (d *CSVDecoder) Decode(v interface{}) error {
+	s := reflect.ValueOf(v).Elem()
+	if err := d.planFor(s.Type()); err != nil {
+		return err
+	}
+
+	record, err := d.reader.Read()
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		line := 0
+		if parseErr, ok := err.(*csv.ParseError); ok {
+			line = parseErr.StartLine
+		}
+		return &CSVError{d.file, line, "", err}
+	}
+	// Quoted cells may span lines, so ask the reader rather than count rows.
+	line, _ := d.reader.FieldPos(0)
+
+	for _, p := range d.plan {
+		value := ""
+		if p.position != -1 && p.position < len(record) {
+			value = strings.TrimSpace(record[p.position])
+		}
+		if value == "" {
+			value = p.fallback
+		}
+		if err := setField(s.Field(p.index), value); err != nil {
+			return &CSVError{d.file, line, p.column, err}
+		}
+	}
+	return nil
+}
+
+// planFor works out which column feeds each field of t, once per decoder.
+func (d *CSVDecoder) planFor(t reflect.Type) error {
+	if d.planType == t {
+		return nil
+	}
+
+	plan := []fieldPlan{}
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("csv")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		column := parts[0]
+		optional := len(parts) > 1 && parts[1] == "optional"
+
+		position, found := d.columns[column]
+		if !found {
+			if !optional {
+				return &CSVError{d.file, 1, column, ErrMissingColumn}
+			}
+			position = -1
+		}
+		plan = append(plan, fieldPlan{i, column, position, t.Field(i).Tag.Get("default")})
+	}
+
+	d.planType = t
+	d.plan = plan
+	return nil
+}
+
+// Implemented by field types with their own GTFS text format, e.g. GTFSTime.
+type csvUnmarshaler interface {
+	UnmarshalCSV(value string) error
+}
+
+func setField(f reflect.Value, value string) error {
+	if u, ok := f.Addr().Interface().(csvUnmarshaler); ok {
+		return u.UnmarshalCSV(value)
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Int:
+		if value == "" {
+			f.SetInt(0)
+			return nil
+		}
+		ival, err := strconv.ParseInt(value, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not an int", ErrBadValue, value)
+		}
+		f.SetInt(ival)
+	case reflect.Float64:
+		if value == "" {
+			f.SetFloat(0)
+			return nil
+		}
+		fval, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a float", ErrBadValue, value)
+		}
+		f.SetFloat(fval)
+	case reflect.Bool:
+		f.SetBool(value == "1")
+	default:
+		return errors.New("unknown type: " + f.Type().String())
+	}
+	return nil
+}
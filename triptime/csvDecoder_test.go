@@ -0,0 +1,110 @@
+package triptime
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A row type of its own, so the tests don't depend on which GTFS fields are optional.
+type testRow struct {
+	Id     string  `csv:"id"`
+	Count  int     `csv:"count"`
+	Ratio  float64 `csv:"ratio,optional" default:"0.5"`
+	Note   string  `csv:"note,optional"`
+	Hidden string
+}
+
+func decodeAll(text string) ([]testRow, error) {
+	decoder, err := NewCSVDecoder("test.txt", strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+	rows := []testRow{}
+	for {
+		row := testRow{}
+		if err := decoder.Decode(&row); err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return rows, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestCSVDecoderColumns(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		want []testRow
+	}{
+		{"in struct order", "id,count,ratio,note\nx,1,0.25,hi\n",
+			[]testRow{{"x", 1, 0.25, "hi", ""}}},
+		{"reordered", "note,ratio,count,id\nhi,0.25,1,x\n",
+			[]testRow{{"x", 1, 0.25, "hi", ""}}},
+		{"unknown columns skipped", "id,extra,count,Hidden\nx,?,1,no\n",
+			[]testRow{{"x", 1, 0.5, "", ""}}},
+		{"optional column missing", "id,count\nx,1\n",
+			[]testRow{{"x", 1, 0.5, "", ""}}},
+		{"optional cell blank", "id,count,ratio\nx,1,\ny,2\n",
+			[]testRow{{"x", 1, 0.5, "", ""}, {"y", 2, 0.5, "", ""}}},
+		{"byte order mark and spaces", "\ufeffid, count\n x , 1\n",
+			[]testRow{{"x", 1, 0.5, "", ""}}},
+	}
+	for _, test := range tests {
+		rows, err := decodeAll(test.csv)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(rows, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, rows, test.want)
+		}
+	}
+}
+
+func TestCSVDecoderErrorsIs(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		want error
+	}{
+		{"missing required column", "id,ratio\nx,0.1\n", ErrMissingColumn},
+		{"bad int", "id,count\nx,one\n", ErrBadValue},
+		{"bad float", "id,count,ratio\nx,1,half\n", ErrBadValue},
+	}
+	for _, test := range tests {
+		_, err := decodeAll(test.csv)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestCSVDecoderErrorLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		csv      string
+		wantLine int
+	}{
+		{"bad value", "stop_id,stop_name,stop_lat,stop_lon\na1,Palo Alto,37.4,-122.1\nb1,SF,north,-122.3\n", 3},
+		{"after a multi-line cell", "stop_id,stop_name,stop_desc,stop_lat,stop_lon\n" +
+			"a1,Palo Alto,\"Platform 1\nPlatform 2\",37.4,-122.1\nb1,SF,,north,-122.3\n", 4},
+		{"bad quoting", "stop_id,stop_name,stop_lat,stop_lon\na1,Palo Alto,37.4,-122.1\nb1,\"S\"F,37.7,-122.3\n", 3},
+	}
+	for _, test := range tests {
+		decoder, err := NewCSVDecoder("stops.txt", strings.NewReader(test.csv))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for err == nil {
+			err = decoder.Decode(&Stop{})
+		}
+		var csvErr *CSVError
+		if !errors.As(err, &csvErr) {
+			t.Errorf("%s: got %v, want a CSVError", test.name, err)
+		} else if csvErr.Line != test.wantLine {
+			t.Errorf("%s: got line %d, want %d: %v", test.name, csvErr.Line, test.wantLine, err)
+		}
+	}
+}
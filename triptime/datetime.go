@@ -2,28 +2,120 @@ package triptime
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func getSFTZ() *time.Location {
-	loc, err := time.LoadLocation("America/Los_Angeles")
-	if err != nil {
-		panic("Don't have the LA timezone?!?! :(")
-	}
-	return loc
+// Clock says what time it is. Schedule queries take the time explicitly, so
+// only the handlers ask the clock (via GTFSData.Now), and a fixed one can stand in for it.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
 }
 
-func getSFTime() time.Time {
-	return time.Now().In(getSFTZ())
+// FixedClock always reports the same time.
+type FixedClock time.Time
+
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
 }
 
+var CLOCK Clock = systemClock{}
+
 func dateAsString(t time.Time) string {
 	return t.Format("20060102")
 }
 
-func secondsUntil(t time.Time, until string) int64 {
+// parseClockTime reads times people type, e.g. "9am", "9:30pm" or "17:45", as the
+// next time the clock shows that after now (so "7am" late at night means tomorrow).
+func parseClockTime(text string, now time.Time) (time.Time, bool) {
+	text = strings.Replace(strings.ToLower(strings.TrimSpace(text)), " ", "", -1)
+	meridiem := ""
+	if strings.HasSuffix(text, "am") || strings.HasSuffix(text, "pm") {
+		meridiem = text[len(text)-2:]
+		text = text[:len(text)-2]
+	}
+	parts := strings.Split(text, ":")
+	if len(parts) > 2 {
+		return time.Time{}, false
+	}
+	hh, err := strconv.Atoi(parts[0])
+	if err != nil || hh < 0 || hh > 23 {
+		return time.Time{}, false
+	}
+	mm := 0
+	if len(parts) == 2 {
+		if mm, err = strconv.Atoi(parts[1]); err != nil || len(parts[1]) != 2 || mm < 0 || mm > 59 {
+			return time.Time{}, false
+		}
+	}
+	if meridiem != "" {
+		if hh < 1 || hh > 12 {
+			return time.Time{}, false
+		}
+		hh = hh % 12
+		if meridiem == "pm" {
+			hh += 12
+		}
+	}
+
+	at := time.Date(now.Year(), now.Month(), now.Day(), hh, mm, 0, 0, now.Location())
+	if at.Before(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, true
+}
+
+// GTFSTime is a stop time as seconds since the start of its service day, so
+// trips running past midnight keep going with values like 25:10:00.
+type GTFSTime int
+
+// UNTIMED is a time left blank, as stops that aren't timepoints may be, until
+// LoadGTFS interpolates it.
+const UNTIMED GTFSTime = -1
+
+func ParseGTFSTime(value string) (GTFSTime, error) {
 	hh, mm, ss := 0, 0, 0
-	fmt.Sscanf(until, "%d:%d:%d", &hh, &mm, &ss)
-	untilT := time.Date(t.Year(), t.Month(), t.Day(), hh, mm, ss, 0, t.Location())
-	return untilT.Unix() - t.Unix()
+	if n, _ := fmt.Sscanf(value, "%d:%d:%d", &hh, &mm, &ss); n != 3 || hh < 0 || mm < 0 || mm > 59 || ss < 0 || ss > 59 {
+		return 0, fmt.Errorf("%w: %q is not a time", ErrBadValue, value)
+	}
+	return GTFSTime(hh*3600 + mm*60 + ss), nil
+}
+
+func (g *GTFSTime) UnmarshalCSV(value string) error {
+	if value == "" {
+		*g = UNTIMED
+		return nil
+	}
+	parsed, err := ParseGTFSTime(value)
+	*g = parsed
+	return err
+}
+
+// String is the wall clock time, HH:MM, wrapping past midnight.
+func (g GTFSTime) String() string {
+	return fmt.Sprintf("%02d:%02d", (int(g)/3600)%24, (int(g)/60)%60)
+}
+
+// On places the time on the calendar for a given service date.
+func (g GTFSTime) On(serviceDate time.Time) time.Time {
+	return serviceDayStart(serviceDate).Add(time.Duration(g) * time.Second)
+}
+
+// GTFS measures times from noon minus 12h, which is midnight except on
+// the days daylight saving starts or ends.
+func serviceDayStart(serviceDate time.Time) time.Time {
+	noon := time.Date(serviceDate.Year(), serviceDate.Month(), serviceDate.Day(), 12, 0, 0, 0, serviceDate.Location())
+	return noon.Add(-12 * time.Hour)
+}
+
+// The calendar date t falls on, at midnight in t's location.
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
 }
@@ -0,0 +1,75 @@
+package triptime
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FeedSource opens the individual files of a GTFS feed by name, e.g. "stops.txt".
+type FeedSource interface {
+	Open(name string) (io.ReadCloser, error)
+}
+
+// OpenFeed picks the right source for a path: a .zip archive or a directory of .txt files.
+func OpenFeed(feedPath string) (FeedSource, error) {
+	info, err := os.Stat(feedPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return DirFeed(feedPath), nil
+	}
+	f, err := os.Open(feedPath)
+	if err != nil {
+		return nil, err
+	}
+	// The archive is read lazily, so the file stays open for as long as the feed is in use.
+	return ZipFeed(f, info.Size())
+}
+
+type dirFeed string
+
+// DirFeed reads an unpacked feed from a directory.
+func DirFeed(dir string) FeedSource {
+	return dirFeed(dir)
+}
+
+func (d dirFeed) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), name))
+}
+
+type zipFeed struct {
+	files map[string]*zip.File
+}
+
+// ZipFeed reads a feed straight from a zip archive, as agencies publish them (google_transit.zip).
+func ZipFeed(r io.ReaderAt, size int64) (FeedSource, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		// Some agencies zip up the containing folder too, so match on file name only.
+		name := path.Base(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if _, seen := files[name]; !seen {
+			files[name] = f
+		}
+	}
+	return zipFeed{files}, nil
+}
+
+func (z zipFeed) Open(name string) (io.ReadCloser, error) {
+	f, found := z.files[name]
+	if !found {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return f.Open()
+}
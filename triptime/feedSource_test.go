@@ -0,0 +1,122 @@
+package triptime
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// memFeed is a feed held in memory, file name to contents.
+type memFeed map[string]string
+
+func (m memFeed) Open(name string) (io.ReadCloser, error) {
+	contents, found := m[name]
+	if !found {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(strings.NewReader(contents)), nil
+}
+
+// zipOf archives a feed, each file under dir if it's not "".
+func zipOf(t *testing.T, files memFeed, dir string) *bytes.Reader {
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+	if dir != "" {
+		if _, err := archive.Create(dir + "/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, contents := range files {
+		w, err := archive.Create(filepath.ToSlash(filepath.Join(dir, name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(contents))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestZipFeed(t *testing.T) {
+	files := memFeed{
+		"agency.txt":            "agency_id\nCT\n",
+		"__MACOSX/._agency.txt": "resource fork",
+	}
+	tests := []struct {
+		name string
+		dir  string
+	}{
+		{"files at the top", ""},
+		{"files in a folder", "google_transit"},
+		{"files in nested folders", "feeds/caltrain"},
+	}
+	for _, test := range tests {
+		r := zipOf(t, files, test.dir)
+		feed, err := ZipFeed(r, r.Size())
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		f, err := feed.Open("agency.txt")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		contents, _ := ioutil.ReadAll(f)
+		f.Close()
+		if string(contents) != files["agency.txt"] {
+			t.Errorf("%s: read %q, want %q", test.name, contents, files["agency.txt"])
+		}
+		if _, err := feed.Open("transfers.txt"); !os.IsNotExist(err) {
+			t.Errorf("%s: opening a missing file got %v, want not exist", test.name, err)
+		}
+	}
+
+	if _, err := ZipFeed(strings.NewReader("not a zip"), 9); err == nil {
+		t.Errorf("got no error for a file that isn't a zip")
+	}
+}
+
+func TestDirAndZipFeedsLoadTheSame(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, contents := range TEST_FEED {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fromDir, err := LoadGTFS(DirFeed(dir))
+	if err != nil {
+		t.Fatalf("DirFeed: %v", err)
+	}
+	r := zipOf(t, TEST_FEED, "google_transit")
+	zipped, err := ZipFeed(r, r.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromZip, err := LoadGTFS(zipped)
+	if err != nil {
+		t.Fatalf("ZipFeed: %v", err)
+	}
+
+	for _, field := range []string{"Agencies", "Routes", "Stops", "StopTimes", "Trips", "ServiceDates", "ServiceDateExceptions", "Transfers"} {
+		got := reflect.ValueOf(fromZip).Elem().FieldByName(field).Interface()
+		want := reflect.ValueOf(fromDir).Elem().FieldByName(field).Interface()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s differ: zip %+v, dir %+v", field, got, want)
+		}
+	}
+	if fromZip.Location.String() != fromDir.Location.String() {
+		t.Errorf("got location %v from the zip, %v from the directory", fromZip.Location, fromDir.Location)
+	}
+}
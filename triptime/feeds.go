@@ -0,0 +1,168 @@
+package triptime
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FeedConfig is one agency's feed. With more than one feed loaded, every ID
+// from it is prefixed with "Name:" so feeds can reuse the same IDs.
+// Path may also be a URL, for realtime feeds.
+type FeedConfig struct {
+	Name string
+	Path string
+}
+
+// gtfsFeeds reads GTFS_FEEDS from app.yaml, e.g. "caltrain=gtfs/caltrain.zip,bart=gtfs/bart.zip",
+// falling back to the single feed at GTFS_PATH.
+func gtfsFeeds() []FeedConfig {
+	list := os.Getenv("GTFS_FEEDS")
+	if list == "" {
+		return []FeedConfig{{"", gtfsPath()}}
+	}
+	return parseFeedList(list)
+}
+
+// parseFeedList reads "name=path,name=path" settings.
+func parseFeedList(list string) []FeedConfig {
+	feeds := []FeedConfig{}
+	for _, entry := range strings.Split(list, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) == 2 {
+			feeds = append(feeds, FeedConfig{strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])})
+		}
+	}
+	return feeds
+}
+
+// Feed location, either an unpacked directory or the agency's zip, set via GTFS_PATH in app.yaml.
+func gtfsPath() string {
+	if p := os.Getenv("GTFS_PATH"); p != "" {
+		return p
+	}
+	return "gtfs"
+}
+
+// LoadFeeds loads each feed and merges them, so queries and journeys span every agency.
+// Schedule maths uses one timezone, so all the feeds need to share it.
+func LoadFeeds(configs []FeedConfig) (*GTFSData, error) {
+	feeds := []*GTFSData{}
+	for _, config := range configs {
+		src, err := OpenFeed(config.Path)
+		if err != nil {
+			return nil, &LoadError{File: config.Path, Err: err, Feed: config.Name}
+		}
+		feed, err := LoadGTFS(src)
+		if err != nil {
+			if loadErr, ok := err.(*LoadError); ok {
+				loadErr.Feed = config.Name
+			}
+			return nil, err
+		}
+		if len(configs) == 1 {
+			return feed, nil
+		}
+		feed.namespace(config.Name)
+		feeds = append(feeds, feed)
+	}
+	if len(feeds) == 0 {
+		return nil, &LoadError{File: "GTFS_FEEDS", Err: fmt.Errorf("no feeds configured")}
+	}
+
+	merged := &GTFSData{
+		Location:      feeds[0].Location,
+		stopLocations: feeds[0].stopLocations,
+	}
+	for i, feed := range feeds {
+		if feed.Location.String() != merged.Location.String() {
+			err := fmt.Errorf("timezone %s doesn't match %s", feed.Location, merged.Location)
+			return nil, &LoadError{File: "agency.txt", Err: err, Feed: configs[i].Name}
+		}
+		for name, loc := range feed.stopLocations {
+			merged.stopLocations[name] = loc
+		}
+		merged.Agencies = append(merged.Agencies, feed.Agencies...)
+		merged.Routes = append(merged.Routes, feed.Routes...)
+		merged.Stops = append(merged.Stops, feed.Stops...)
+		merged.StopTimes = append(merged.StopTimes, feed.StopTimes...)
+		merged.ServiceDates = append(merged.ServiceDates, feed.ServiceDates...)
+		merged.ServiceDateExceptions = append(merged.ServiceDateExceptions, feed.ServiceDateExceptions...)
+		merged.Trips = append(merged.Trips, feed.Trips...)
+		merged.Transfers = append(merged.Transfers, feed.Transfers...)
+	}
+	merged.buildIndex()
+	return merged, nil
+}
+
+// namespace prefixes every ID in the feed, which needs its index rebuilding afterwards.
+func (d *GTFSData) namespace(name string) {
+	id := func(value string) string {
+		if value == "" {
+			return ""
+		}
+		return name + ":" + value
+	}
+	for i := range d.Agencies {
+		d.Agencies[i].AgencyId = agencyFeedId(name, d.Agencies[i].AgencyId)
+	}
+	for i := range d.Routes {
+		d.Routes[i].RouteId = id(d.Routes[i].RouteId)
+		d.Routes[i].AgencyId = agencyFeedId(name, d.Routes[i].AgencyId)
+	}
+	for i := range d.Stops {
+		d.Stops[i].StopId = id(d.Stops[i].StopId)
+		d.Stops[i].Parent = id(d.Stops[i].Parent)
+	}
+	for i := range d.StopTimes {
+		d.StopTimes[i].TripId = id(d.StopTimes[i].TripId)
+		d.StopTimes[i].StopId = id(d.StopTimes[i].StopId)
+	}
+	for i := range d.ServiceDates {
+		d.ServiceDates[i].ServiceId = id(d.ServiceDates[i].ServiceId)
+	}
+	for i := range d.ServiceDateExceptions {
+		d.ServiceDateExceptions[i].ServiceId = id(d.ServiceDateExceptions[i].ServiceId)
+	}
+	for i := range d.Trips {
+		d.Trips[i].RouteId = id(d.Trips[i].RouteId)
+		d.Trips[i].ServiceId = id(d.Trips[i].ServiceId)
+		d.Trips[i].TripId = id(d.Trips[i].TripId)
+		d.Trips[i].BlockId = id(d.Trips[i].BlockId)
+	}
+	for i := range d.Transfers {
+		d.Transfers[i].FromStopId = id(d.Transfers[i].FromStopId)
+		d.Transfers[i].ToStopId = id(d.Transfers[i].ToStopId)
+		d.Transfers[i].FromRouteId = id(d.Transfers[i].FromRouteId)
+		d.Transfers[i].ToRouteId = id(d.Transfers[i].ToRouteId)
+		d.Transfers[i].FromTripId = id(d.Transfers[i].FromTripId)
+		d.Transfers[i].ToTripId = id(d.Transfers[i].ToTripId)
+	}
+}
+
+// agencyFeedId is an agency_id as the merged feeds know it. Unlike other IDs a blank
+// one is prefixed too, since it's still an agency: the one a single-agency feed runs.
+func agencyFeedId(feedName string, agencyId string) string {
+	if feedName == "" {
+		return agencyId
+	}
+	return feedName + ":" + agencyId
+}
+
+// AgencyFor is the agency running a route, nil if the feed doesn't say.
+func (d *GTFSData) AgencyFor(routeId string) *Agency {
+	route := d.GetRoute(routeId)
+	if route == nil {
+		return nil
+	}
+	return d.index.agencies[route.AgencyId]
+}
+
+// RouteLabel names a route with its agency, e.g. "Caltrain Baby Bullet", for replies.
+func (d *GTFSData) RouteLabel(routeId string) string {
+	name := d.RouteName(routeId)
+	if agency := d.AgencyFor(routeId); agency != nil {
+		return strings.TrimSpace(agency.Name + " " + name)
+	}
+	return name
+}
@@ -0,0 +1,145 @@
+package triptime
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+// writeFeed unpacks a feed into a new directory under dir, for LoadFeeds to open.
+func writeFeed(t *testing.T, dir string, name string, files memFeed) FeedConfig {
+	path := filepath.Join(dir, name)
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for file, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(path, file), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return FeedConfig{name, path}
+}
+
+// Caltrain and BART, both using stop "mb" for Millbrae and "t1" for a trip.
+// BART's agency.txt leaves agency_id out, as single-agency feeds may.
+func caltrainAndBart(t *testing.T, dir string, bartTimezone string) []FeedConfig {
+	calendar := "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nD,1,1,1,1,1,1,1,20260101,20261231\n"
+	stopTimes := "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nt1,07:00:00,07:00:00,a,1\nt1,07:20:00,07:20:00,mb,2\n"
+	return []FeedConfig{
+		writeFeed(t, dir, "caltrain", memFeed{
+			"agency.txt":     "agency_id,agency_name,agency_url,agency_timezone\nCT,Caltrain,http://caltrain.com,America/Los_Angeles\n",
+			"calendar.txt":   calendar,
+			"routes.txt":     "route_id,agency_id,route_short_name,route_type\nL,CT,Local,2\n",
+			"stops.txt":      "stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station\nmb,Millbrae,37.60,-122.39,1,\nmb1,Millbrae,37.60,-122.39,0,mb\na,Palo Alto,37.44,-122.16,,\n",
+			"trips.txt":      "route_id,service_id,trip_id\nL,D,t1\n",
+			"stop_times.txt": strings.Replace(stopTimes, ",mb,", ",mb1,", 1),
+			"transfers.txt":  "from_stop_id,to_stop_id,transfer_type\nmb1,mb1,1\n",
+		}),
+		writeFeed(t, dir, "bart", memFeed{
+			"agency.txt":     "agency_name,agency_url,agency_timezone\nBART,http://bart.gov," + bartTimezone + "\n",
+			"calendar.txt":   calendar,
+			"routes.txt":     "route_id,route_short_name,route_type\nY,Yellow,1\n",
+			"stops.txt":      "stop_id,stop_name,stop_lat,stop_lon\nmb,Millbrae,37.60,-122.39\na,Antioch,38.00,-121.78\n",
+			"trips.txt":      "route_id,service_id,trip_id\nY,D,t1\n",
+			"stop_times.txt": stopTimes,
+		}),
+	}
+}
+
+func TestLoadFeedsMerges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feeds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := LoadFeeds(caltrainAndBart(t, dir, "America/Los_Angeles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.Agencies) != 2 || len(d.Stops) != 5 || len(d.Trips) != 2 || len(d.StopTimes) != 4 {
+		t.Errorf("got %d agencies, %d stops, %d trips, %d stop times, want 2, 5, 2, 4",
+			len(d.Agencies), len(d.Stops), len(d.Trips), len(d.StopTimes))
+	}
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"agency", d.Agencies[0].AgencyId, "caltrain:CT"},
+		{"unnamed agency", d.Agencies[1].AgencyId, "bart:"},
+		{"route's agency", d.GetRoute("bart:Y").AgencyId, "bart:"},
+		{"route label", d.RouteLabel("bart:Y"), "BART Yellow"},
+		{"parent station", d.GetStop("caltrain:mb1").Parent, "caltrain:mb"},
+		{"same stop ID", d.GetStop("bart:mb").Name, "Millbrae"},
+		{"trip's service", d.GetTrip("bart:t1").ServiceId, "bart:D"},
+		{"stop time's trip", d.SortedStopTimesForTrip("caltrain:t1")[1].StopId, "caltrain:mb1"},
+		{"transfer", d.Transfers[0].FromStopId, "caltrain:mb1"},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, test.got, test.want)
+		}
+	}
+
+}
+
+func TestLoadFeedsTimezoneMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feeds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, err = LoadFeeds(caltrainAndBart(t, dir, "America/New_York"))
+	var loadErr *LoadError
+	if !errors.As(err, &loadErr) || loadErr.Feed != "bart" || loadErr.File != "agency.txt" {
+		t.Fatalf("got %v, want a LoadError for bart's agency.txt", err)
+	}
+	if !strings.Contains(err.Error(), "America/New_York") {
+		t.Errorf("got %q, want it to name the timezone", err)
+	}
+}
+
+func TestAlertForOneFeedsAgency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feeds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := LoadFeeds(caltrainAndBart(t, dir, "America/Los_Angeles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := func(s string) *gtfs.TranslatedString {
+		return &gtfs.TranslatedString{Translation: []*gtfs.TranslatedString_Translation{{Text: &s}}}
+	}
+	agencyAlert := func(agencyId string) *gtfs.FeedMessage {
+		return &gtfs.FeedMessage{Entity: []*gtfs.FeedEntity{{Alert: &gtfs.Alert{
+			HeaderText:     text(agencyId + " strike"),
+			InformedEntity: []*gtfs.EntitySelector{{AgencyId: &agencyId}},
+		}}}}
+	}
+	rt := NewRealtime(time.Time{})
+	// BART's realtime feed names its agency, though its agency.txt doesn't.
+	rt.AddFeed(d, agencyAlert("BART"), "bart")
+	rt.AddFeed(d, agencyAlert("CT"), "caltrain")
+	d.SetRealtime(rt)
+
+	now := at(t, d, "2026-10-21 06:00")
+	for _, feed := range []string{"bart", "caltrain"} {
+		station := *d.GetStop(feed + ":a")
+		trips, _ := d.NextTripsAtStop(station, now)
+		alerts := d.AlertsFor(station, trips, now)
+		want := map[string]string{"bart": "BART strike", "caltrain": "CT strike"}[feed]
+		if len(alerts) != 1 || alerts[0].Header != want {
+			t.Errorf("%s: got %+v, want just %q", feed, alerts, want)
+		}
+	}
+}
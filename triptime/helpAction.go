@@ -5,37 +5,32 @@ import (
 	// "strconv"
 	// "strings"
 
-	"github.com/padster/triptime/fb"
-
 	ctx "golang.org/x/net/context"
 	// "google.golang.org/appengine/log"
 )
 
 // Given a location, tell the times of the next N trains leaving from this station.
-func helpAction(c ctx.Context, msg fb.Message) fb.OutboundMessage {
-	state := GetUserState(c, msg.Sender.Id)
+func helpAction(c ctx.Context, req Request) Reply {
+	state := GetUserState(c, req.UserId)
 	if state == nil {
-		return welcomeMessage(msg)
+		return welcomeMessage()
 	} else {
-		return stateMessage(msg, state)
+		return stateMessage(state)
 	}
 }
 
-func welcomeMessage(msg fb.Message) fb.OutboundMessage {
+func welcomeMessage() Reply {
 	text := "Welcome to Triptime 🚆\n"
 	text += "I don't know where you are, so first please send me your "
-	text += "location using the marker, not your live location.\n"
-	return fb.OutboundMessage{
-		msg.Sender,
-		outMessageDataFromText(text),
-	}
+	text += "location using the marker, not your live location, or the name of the station you're at.\n"
+	return textReply(text)
 }
 
-func stateMessage(msg fb.Message, state *UserState) fb.OutboundMessage {
+func stateMessage(state *UserState) Reply {
 	text := fmt.Sprintf("You're near %s, so use 'Next' to see the next trains near you.\n", state.StopAt.Name)
 	text += "You can state how many to see, and which direction you want - e.g. Next 5 NB\n"
-	return fb.OutboundMessage{
-		msg.Sender,
-		outMessageDataFromText(text),
-	}
+	text += "To plan a trip, say where you're going - e.g. To San Francisco, or From Palo Alto to Millbrae\n"
+	text += "Or say when you need to be there - e.g. Arrive San Francisco by 9am\n"
+	text += "Say 'alerts' to hear about delays and disruptions, or ask after a train - e.g. Where is train 123\n"
+	return textReply(text)
 }
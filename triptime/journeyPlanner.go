@@ -0,0 +1,377 @@
+package triptime
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	MAX_ITINERARIES = 3
+	// How far ahead of the departure time to look for connections.
+	PLAN_HORIZON = 24 * time.Hour
+)
+
+// One hop of a trip between consecutive stops, the unit the Connection Scan Algorithm works with.
+type connection struct {
+	trip        *Trip
+	serviceDate time.Time
+	from        string // Platform stop IDs.
+	to          string
+	departs     time.Time
+	arrives     time.Time
+	canBoard    bool // Whether passengers can get on at from,
+	canAlight   bool // and off at to.
+}
+
+// JourneyLeg is a ride on one train, from boarding to getting off.
+type JourneyLeg struct {
+	Trip        *Trip
+	ServiceDate time.Time
+	From        Stop
+	To          Stop
+	Departs     time.Time
+	Arrives     time.Time
+}
+
+// Itinerary is a way to get between two stations, changing trains between legs.
+type Itinerary struct {
+	Legs []JourneyLeg
+}
+
+func (it Itinerary) Departs() time.Time {
+	return it.Legs[0].Departs
+}
+
+func (it Itinerary) Arrives() time.Time {
+	return it.Legs[len(it.Legs)-1].Arrives
+}
+
+// PlanJourney finds up to MAX_ITINERARIES ways to get from one station to another,
+// leaving at or after t, each the earliest arrival for a later departure than the last.
+func (d *GTFSData) PlanJourney(from Stop, to Stop, t time.Time) []Itinerary {
+	connections := d.connectionsAfter(t)
+	itineraries := []Itinerary{}
+	for len(itineraries) < MAX_ITINERARIES {
+		it, found := d.earliestArrival(connections, from, to, t)
+		if !found {
+			break
+		}
+		itineraries = append(itineraries, it)
+		t = it.Departs().Add(time.Second)
+	}
+	return itineraries
+}
+
+// PlanJourneyArriveBy is the reverse search: up to MAX_ITINERARIES ways to get
+// from one station to another by the deadline, leaving at or after t, latest departure first.
+func (d *GTFSData) PlanJourneyArriveBy(from Stop, to Stop, t time.Time, by time.Time) []Itinerary {
+	connections := d.connectionsBetween(t, by)
+	byDeparture := append([]connection{}, connections...)
+	sort.Sort(ConnectionsByDeparture(byDeparture))
+	origin := map[string]bool{}
+	for _, platform := range d.DirectionalStops(from, "") {
+		origin[platform.StopId] = true
+	}
+
+	itineraries := []Itinerary{}
+	departBefore := by.Add(time.Second)
+	for len(itineraries) < MAX_ITINERARIES {
+		it, found := d.latestDeparture(connections, from, to, by, departBefore)
+		if !found {
+			break
+		}
+		// That's the latest train to leave on, but the legs after it are only the
+		// latest that make the deadline. Scan forward from it for the earliest arrival,
+		// ignoring the later departures already offered.
+		forward := []connection{}
+		for _, c := range byDeparture {
+			if !origin[c.from] || c.departs.Before(departBefore) {
+				forward = append(forward, c)
+			}
+		}
+		if quickest, found := d.earliestArrival(forward, from, to, it.Departs()); found {
+			it = quickest
+		}
+		itineraries = append(itineraries, it)
+		departBefore = it.Departs()
+	}
+	return itineraries
+}
+
+// connectionsAfter lists every connection departing in [t, t + PLAN_HORIZON), by departure time.
+func (d *GTFSData) connectionsAfter(t time.Time) []connection {
+	days := d.ServiceDaysAround(t)
+	tomorrow := dateOf(t.In(d.Location)).AddDate(0, 0, 1)
+	days = append(days, d.serviceDay(tomorrow))
+
+	horizon := t.Add(PLAN_HORIZON)
+	rt := d.Realtime()
+	connections := []connection{}
+	for _, day := range days {
+		for _, trip := range day.Trips {
+			for _, c := range d.tripConnections(trip, day.Date, rt) {
+				if !c.departs.Before(t) && c.departs.Before(horizon) {
+					connections = append(connections, c)
+				}
+			}
+		}
+	}
+	sort.Sort(ConnectionsByDeparture(connections))
+	return connections
+}
+
+// connectionsBetween lists every connection departing from t and arriving by the deadline, latest arrival first.
+func (d *GTFSData) connectionsBetween(t time.Time, by time.Time) []connection {
+	rt := d.Realtime()
+	connections := []connection{}
+	for _, day := range d.ServiceDaysAround(by) {
+		for _, trip := range day.Trips {
+			for _, c := range d.tripConnections(trip, day.Date, rt) {
+				if !c.arrives.After(by) && !c.departs.Before(t) {
+					connections = append(connections, c)
+				}
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(ConnectionsByArrival(connections)))
+	return connections
+}
+
+// tripConnections splits one day's run of a trip into connections, at the times the
+// realtime feed predicts. Stops the train won't call at are passed straight through.
+func (d *GTFSData) tripConnections(trip *Trip, serviceDate time.Time, rt *Realtime) []connection {
+	stopTimes := d.SortedStopTimesForTrip(trip.TripId)
+	connections := []connection{}
+	var last *StopTime
+	var leaves time.Time // When the train leaves last.
+	for i := range stopTimes {
+		reaching := rt.Predict(trip, serviceDate, &stopTimes[i])
+		if reaching.Cancelled {
+			continue
+		}
+		// Delays are predicted stop by stop, and can disagree enough to send the
+		// train back in time, which the scans below can't cope with.
+		arrives := stopTimes[i].Arrival.On(serviceDate).Add(reaching.ArrivalDelay)
+		if last != nil && arrives.Before(leaves) {
+			arrives = leaves
+		}
+		if last != nil {
+			connections = append(connections, connection{
+				trip,
+				serviceDate,
+				last.StopId,
+				stopTimes[i].StopId,
+				leaves,
+				arrives,
+				last.PickupType.Allowed(),
+				stopTimes[i].DropoffType.Allowed(),
+			})
+		}
+		departs := stopTimes[i].Departure.On(serviceDate).Add(reaching.DepartureDelay)
+		if departs.Before(arrives) {
+			departs = arrives
+		}
+		last, leaves = &stopTimes[i], departs
+	}
+	return connections
+}
+
+// How a platform was first reached (or, searching backwards, last left): the leg of a trip between them.
+type arrivalLabel struct {
+	boarded  *connection // First connection of the leg.
+	alighted *connection // Last connection of the leg.
+}
+
+// earliestArrival is one run of the Connection Scan Algorithm.
+func (d *GTFSData) earliestArrival(connections []connection, from Stop, to Stop, t time.Time) (Itinerary, bool) {
+	// ready is when a train can be caught at a platform, readyFrom the platform
+	// whose arrival made that possible ("" for the origin).
+	ready := map[string]time.Time{}
+	readyFrom := map[string]string{}
+	arrived := map[string]time.Time{}
+	labels := map[string]arrivalLabel{}
+	boarded := map[string]*connection{}
+
+	for _, platform := range d.DirectionalStops(from, "") {
+		ready[platform.StopId] = t
+		readyFrom[platform.StopId] = ""
+	}
+	destination := map[string]bool{}
+	for _, platform := range d.DirectionalStops(to, "") {
+		destination[platform.StopId] = true
+	}
+
+	var best *connection
+	for i := range connections {
+		c := &connections[i]
+		if best != nil && !c.departs.Before(best.arrives) {
+			// Nothing departing now can arrive any sooner.
+			break
+		}
+
+		tripKey := c.trip.TripId + "@" + dateAsString(c.serviceDate)
+		if boarded[tripKey] == nil {
+			readyAt, reachable := ready[c.from]
+			if !reachable || readyAt.After(c.departs) || !c.canBoard {
+				continue
+			}
+			boarded[tripKey] = c
+		}
+		if !c.canAlight {
+			continue
+		}
+
+		if at, seen := arrived[c.to]; seen && !c.arrives.Before(at) {
+			continue
+		}
+		arrived[c.to] = c.arrives
+		labels[c.to] = arrivalLabel{boarded[tripKey], c}
+		if destination[c.to] {
+			// Platforms are tracked separately, so this may not beat another one's arrival.
+			if best == nil || c.arrives.Before(best.arrives) {
+				best = c
+			}
+			continue
+		}
+		for _, change := range d.changesFrom(c.to) {
+			readyAt := c.arrives.Add(change.duration)
+			if at, seen := ready[change.stopId]; !seen || readyAt.Before(at) {
+				ready[change.stopId] = readyAt
+				readyFrom[change.stopId] = c.to
+			}
+		}
+	}
+
+	if best == nil {
+		return Itinerary{}, false
+	}
+	return d.buildItinerary(labels, readyFrom, best.to), true
+}
+
+// latestDeparture is one run of the Connection Scan Algorithm backwards in time,
+// only considering departures from the origin before departBefore.
+func (d *GTFSData) latestDeparture(connections []connection, from Stop, to Stop, by time.Time, departBefore time.Time) (Itinerary, bool) {
+	// deadline is the latest a train can arrive at a platform and still make it,
+	// deadlineFor the platform the next leg leaves from ("" at the destination).
+	deadline := map[string]time.Time{}
+	deadlineFor := map[string]string{}
+	departed := map[string]time.Time{}
+	labels := map[string]arrivalLabel{}
+	alighted := map[string]*connection{}
+
+	for _, platform := range d.DirectionalStops(to, "") {
+		deadline[platform.StopId] = by
+		deadlineFor[platform.StopId] = ""
+	}
+	origin := map[string]bool{}
+	for _, platform := range d.DirectionalStops(from, "") {
+		origin[platform.StopId] = true
+	}
+
+	var best *connection
+	for i := range connections {
+		c := &connections[i]
+		if best != nil && !c.arrives.After(best.departs) {
+			// Nothing arriving this early can leave any later.
+			break
+		}
+
+		tripKey := c.trip.TripId + "@" + dateAsString(c.serviceDate)
+		if alighted[tripKey] == nil {
+			deadlineAt, reachable := deadline[c.to]
+			if !reachable || c.arrives.After(deadlineAt) || !c.canAlight {
+				continue
+			}
+			alighted[tripKey] = c
+		}
+		if !c.canBoard {
+			continue
+		}
+
+		if at, seen := departed[c.from]; seen && !c.departs.After(at) {
+			continue
+		}
+		if origin[c.from] {
+			if !c.departs.Before(departBefore) {
+				continue
+			}
+			departed[c.from] = c.departs
+			labels[c.from] = arrivalLabel{c, alighted[tripKey]}
+			if best == nil || c.departs.After(best.departs) {
+				best = c
+			}
+			continue
+		}
+		departed[c.from] = c.departs
+		labels[c.from] = arrivalLabel{c, alighted[tripKey]}
+		for _, change := range d.changesTo(c.from) {
+			deadlineAt := c.departs.Add(-change.duration)
+			if at, seen := deadline[change.stopId]; !seen || deadlineAt.After(at) {
+				deadline[change.stopId] = deadlineAt
+				deadlineFor[change.stopId] = c.from
+			}
+		}
+	}
+
+	if best == nil {
+		return Itinerary{}, false
+	}
+	legs := []JourneyLeg{}
+	for at := best.from; at != ""; {
+		label := labels[at]
+		board, alight := label.boarded, label.alighted
+		legs = append(legs, JourneyLeg{
+			board.trip,
+			board.serviceDate,
+			*d.GetStop(board.from),
+			*d.GetStop(alight.to),
+			board.departs,
+			alight.arrives,
+		})
+		at = deadlineFor[alight.to]
+	}
+	return Itinerary{legs}, true
+}
+
+// Walks back from the destination platform to the origin, leg by leg.
+func (d *GTFSData) buildItinerary(labels map[string]arrivalLabel, readyFrom map[string]string, at string) Itinerary {
+	legs := []JourneyLeg{}
+	for at != "" {
+		label := labels[at]
+		board, alight := label.boarded, label.alighted
+		legs = append([]JourneyLeg{{
+			board.trip,
+			board.serviceDate,
+			*d.GetStop(board.from),
+			*d.GetStop(alight.to),
+			board.departs,
+			alight.arrives,
+		}}, legs...)
+		at = readyFrom[board.from]
+	}
+	return Itinerary{legs}
+}
+
+type ConnectionsByDeparture []connection
+
+func (cs ConnectionsByDeparture) Len() int {
+	return len(cs)
+}
+func (cs ConnectionsByDeparture) Swap(i, j int) {
+	cs[i], cs[j] = cs[j], cs[i]
+}
+func (cs ConnectionsByDeparture) Less(i, j int) bool {
+	return cs[i].departs.Before(cs[j].departs)
+}
+
+type ConnectionsByArrival []connection
+
+func (cs ConnectionsByArrival) Len() int {
+	return len(cs)
+}
+func (cs ConnectionsByArrival) Swap(i, j int) {
+	cs[i], cs[j] = cs[j], cs[i]
+}
+func (cs ConnectionsByArrival) Less(i, j int) bool {
+	return cs[i].arrives.Before(cs[j].arrives)
+}
@@ -0,0 +1,140 @@
+package triptime
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Stations with a platform each way, so the best trip can use either.
+var TWO_PLATFORM_STOPS = `stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station,platform_code
+o,Origin,37.4,-122.1,1,,
+o1,Origin,37.4,-122.1,0,o,NB
+o2,Origin,37.4,-122.1,0,o,SB
+d,Destination,37.7,-122.4,1,,
+d1,Destination,37.7,-122.4,0,d,NB
+d2,Destination,37.7,-122.4,0,d,SB
+`
+
+func TestPlanJourneyEarliestArrivalAcrossPlatforms(t *testing.T) {
+	d := loadFeed(t, TEST_FEED, memFeed{
+		"stops.txt": TWO_PLATFORM_STOPS,
+		"trips.txt": `route_id,service_id,trip_id,trip_short_name
+L1,WD,fast,301
+L1,WD,slow,101
+`,
+		"stop_times.txt": `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+fast,08:00:00,08:00:00,o1,1
+fast,09:30:00,09:30:00,d1,2
+slow,08:10:00,08:10:00,o1,1
+slow,10:00:00,10:00:00,d2,2
+`,
+	})
+	itineraries := d.PlanJourney(*d.GetStop("o"), *d.GetStop("d"), at(t, d, "2026-10-21 07:00"))
+	if len(itineraries) == 0 {
+		t.Fatal("no journeys found")
+	}
+	first := itineraries[0].Legs[0]
+	if first.Trip.TripId != "fast" || !first.Arrives.Equal(at(t, d, "2026-10-21 09:30")) {
+		t.Errorf("first journey is trip %s arriving %s, want fast arriving 09:30", first.Trip.TripId, first.Arrives)
+	}
+}
+
+func TestPlanJourneyArriveByLatestDepartureAcrossPlatforms(t *testing.T) {
+	d := loadFeed(t, TEST_FEED, memFeed{
+		"stops.txt": TWO_PLATFORM_STOPS,
+		"trips.txt": `route_id,service_id,trip_id,trip_short_name
+L1,WD,early,101
+L1,WD,later,103
+`,
+		"stop_times.txt": `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+early,07:00:00,07:00:00,o2,1
+early,08:30:00,08:30:00,d1,2
+later,08:00:00,08:00:00,o1,1
+later,09:00:00,09:00:00,d1,2
+`,
+	})
+	itineraries := d.PlanJourneyArriveBy(*d.GetStop("o"), *d.GetStop("d"),
+		at(t, d, "2026-10-21 06:00"), at(t, d, "2026-10-21 09:30"))
+	if len(itineraries) == 0 {
+		t.Fatal("no journeys found")
+	}
+	first := itineraries[0].Legs[0]
+	if first.Trip.TripId != "later" || !first.Departs.Equal(at(t, d, "2026-10-21 08:00")) {
+		t.Errorf("first journey is trip %s leaving %s, want later leaving 08:00", first.Trip.TripId, first.Departs)
+	}
+	if len(itineraries) < 2 || itineraries[1].Legs[0].Trip.TripId != "early" {
+		t.Errorf("got %d journeys, want the early trip offered second", len(itineraries))
+	}
+}
+
+func TestTripConnectionsNeverGoBackInTime(t *testing.T) {
+	d, err := LoadGTFS(memFeed{
+		"agency.txt":   "agency_id,agency_name,agency_url,agency_timezone\nCT,Caltrain,http://caltrain.com,America/Los_Angeles\n",
+		"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nD,1,1,1,1,1,1,1,20260101,20261231\n",
+		"routes.txt":   "route_id,route_type\nL1,2\n",
+		"stops.txt":    "stop_id,stop_name,stop_lat,stop_lon\nsj,San Jose,37.33,-121.90\npa,Palo Alto,37.44,-122.16\nsf,San Francisco,37.77,-122.39\n",
+		"trips.txt":    "route_id,service_id,trip_id\nL1,D,t1\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"t1,07:00:00,07:00:00,sj,1\nt1,07:20:00,07:22:00,pa,2\nt1,08:00:00,08:00:00,sf,3\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leaving San Jose 15 minutes late, but due in Palo Alto 10 minutes early and
+	// leaving there before it arrives.
+	date := dateOf(at(t, d, "2026-10-21 12:00"))
+	rt := NewRealtime(time.Time{})
+	rt.trips["t1@20261021"] = &tripUpdate{stops: []stopUpdate{
+		{seq: 1, hasDeparture: true, departure: 15 * time.Minute},
+		{seq: 2, hasArrival: true, arrival: -10 * time.Minute, hasDeparture: true, departure: -15 * time.Minute},
+	}}
+
+	connections := d.tripConnections(d.GetTrip("t1"), date, rt)
+	if len(connections) != 2 {
+		t.Fatalf("got %d connections, want 2", len(connections))
+	}
+	want := []string{"07:15 07:15", "07:15 07:45"}
+	for i, c := range connections {
+		got := c.departs.Format("15:04") + " " + c.arrives.Format("15:04")
+		if got != want[i] {
+			t.Errorf("connection %s-%s: got %s, want %s", c.from, c.to, got, want[i])
+		}
+	}
+
+	d.SetRealtime(rt)
+	itineraries := d.PlanJourney(*d.GetStop("sj"), *d.GetStop("sf"), at(t, d, "2026-10-21 06:00"))
+	if len(itineraries) != 1 || !itineraries[0].Arrives().Equal(at(t, d, "2026-10-21 07:45")) {
+		t.Errorf("got %+v, want t1 arriving 07:45", itineraries)
+	}
+}
+
+func TestPlanJourneyArriveByTakesTheFirstConnection(t *testing.T) {
+	// The bullet to Millbrae connects with either of two trains to the airport.
+	d, err := LoadGTFS(memFeed{
+		"agency.txt":   "agency_id,agency_name,agency_url,agency_timezone\nCT,Caltrain,http://caltrain.com,America/Los_Angeles\n",
+		"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nD,1,1,1,1,1,1,1,20260101,20261231\n",
+		"routes.txt":   "route_id,route_type\nCT,2\nBART,1\n",
+		"stops.txt":    "stop_id,stop_name,stop_lat,stop_lon\npa,Palo Alto,37.44,-122.16\nmb,Millbrae,37.60,-122.39\nsfo,SFO,37.62,-122.39\n",
+		"trips.txt":    "route_id,service_id,trip_id\nCT,D,bullet\nBART,D,b1\nBART,D,b2\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"bullet,07:10:00,07:10:00,pa,1\nbullet,07:25:00,07:25:00,mb,2\n" +
+			"b1,07:35:00,07:35:00,mb,1\nb1,07:45:00,07:45:00,sfo,2\n" +
+			"b2,08:35:00,08:35:00,mb,1\nb2,08:45:00,08:45:00,sfo,2\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	itineraries := d.PlanJourneyArriveBy(*d.GetStop("pa"), *d.GetStop("sfo"),
+		at(t, d, "2026-10-21 06:00"), at(t, d, "2026-10-21 09:00"))
+	if len(itineraries) != 1 {
+		t.Fatalf("got %d journeys, want 1", len(itineraries))
+	}
+	got := []string{}
+	for _, leg := range itineraries[0].Legs {
+		got = append(got, leg.Trip.TripId+" "+leg.Departs.Format("15:04"))
+	}
+	if strings.Join(got, ", ") != "bullet 07:10, b1 07:35" {
+		t.Errorf("got %v, want the bullet then the 07:35", got)
+	}
+}
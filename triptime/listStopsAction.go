@@ -0,0 +1,98 @@
+package triptime
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	ctx "golang.org/x/net/context"
+)
+
+// Stops listed per reply, to avoid hitting message limits.
+const STOPS_PER_REPLY = 8
+
+// List a trip's stops from the one the rider is at, and when it calls at each.
+// serviceDate is YYYYMMDD, or "" from buttons sent before they carried one, meaning today.
+func listStopsAction(c ctx.Context, req Request, stopId string, tripId string, serviceDate string) []Reply {
+	date, err := time.ParseInLocation("20060102", serviceDate, DATA.Location)
+	if err != nil {
+		date = dateOf(DATA.Now())
+	}
+	trip := DATA.GetTrip(tripId)
+	if trip == nil {
+		return []Reply{textReply("I got distracted, sorry... Please ask again.")}
+	}
+	rt := DATA.Realtime()
+
+	replies := []Reply{}
+	text := ""
+	tripsAdded := 0
+	stopTimes := DATA.SortedStopTimesForTrip(tripId)
+	found := false
+	for i, stopTime := range stopTimes {
+		if stopTime.StopId == stopId {
+			found = true
+		}
+		stop := DATA.GetStop(stopTime.StopId)
+		if found && stop != nil {
+			shortName := shortStopName(stop.Name)
+			p := rt.Predict(trip, date, &stopTimes[i])
+			text += fmt.Sprintf("🕓 %s - %s%s\n", stopTimeText(stopTime, p), shortName, boardingNote(stopTime))
+			tripsAdded++
+			if tripsAdded == STOPS_PER_REPLY {
+				tripsAdded = 0
+				replies = append(replies, textReply(text))
+				text = ""
+			}
+		}
+	}
+	if text == "" && len(replies) == 0 {
+		return []Reply{textReply("I got distracted, sorry... Please ask again.")}
+	}
+	if text == "" {
+		text = "That's the end of the line."
+	}
+	last := textReply(text)
+	last.AddChoice("Where is it now?", whereIsPayload(trip, date))
+	return append(replies, last)
+}
+
+// stopTimeText is when a train calls at a stop, giving arrival and departure
+// separately if it waits there, e.g. at a terminal or a timed stop.
+func stopTimeText(stopTime StopTime, p Prediction) string {
+	if stopTime.Arrival == stopTime.Departure || p.Cancelled {
+		return p.Describe(stopTime.Arrival, p.ArrivalDelay)
+	}
+	return fmt.Sprintf("arr %s, dep %s",
+		p.Describe(stopTime.Arrival, p.ArrivalDelay),
+		p.Describe(stopTime.Departure, p.DepartureDelay))
+}
+
+// boardingNote flags stops that aren't a regular stop for getting off (or on).
+func boardingNote(stopTime StopTime) string {
+	switch {
+	case !stopTime.DropoffType.Allowed():
+		return " (no drop-off)"
+	case !stopTime.PickupType.Allowed():
+		return " (drop-off only)"
+	case stopTime.DropoffType == BOARDING_PHONE_AGENCY || stopTime.PickupType == BOARDING_PHONE_AGENCY:
+		return " (call ahead)"
+	case stopTime.DropoffType == BOARDING_ASK_DRIVER || stopTime.PickupType == BOARDING_ASK_DRIVER:
+		return " (request stop)"
+	}
+	return ""
+}
+
+// listStopsPayload is the postback for a choice listing a trip's stops from one stop on.
+func listStopsPayload(from Stop, trip *Trip, serviceDate time.Time) string {
+	return strings.Join([]string{"liststops", from.StopId, trip.TripId, dateAsString(serviceDate)}, "/")
+}
+
+func shortStopName(name string) string {
+	pos := strings.LastIndex(name, " Caltrain")
+	if pos != -1 {
+		return name[:pos]
+	} else {
+		return name
+	}
+}
@@ -0,0 +1,50 @@
+package triptime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBoardingNote(t *testing.T) {
+	tests := []struct {
+		pickup  BoardingType
+		dropoff BoardingType
+		want    string
+	}{
+		{BOARDING_REGULAR, BOARDING_REGULAR, ""},
+		{BOARDING_REGULAR, BOARDING_NONE, " (no drop-off)"},
+		{BOARDING_NONE, BOARDING_REGULAR, " (drop-off only)"},
+		{BOARDING_REGULAR, BOARDING_PHONE_AGENCY, " (call ahead)"},
+		{BOARDING_ASK_DRIVER, BOARDING_ASK_DRIVER, " (request stop)"},
+	}
+	for _, test := range tests {
+		stopTime := StopTime{PickupType: test.pickup, DropoffType: test.dropoff}
+		if got := boardingNote(stopTime); got != test.want {
+			t.Errorf("pickup %d, drop-off %d: got %q, want %q", test.pickup, test.dropoff, got, test.want)
+		}
+	}
+}
+
+func TestStopTimeText(t *testing.T) {
+	tests := []struct {
+		name       string
+		arrival    string
+		departure  string
+		prediction Prediction
+		want       string
+	}{
+		{"through stop", "07:00:00", "07:00:00", Prediction{}, "07:00"},
+		{"timed stop", "07:00:00", "07:12:00", Prediction{}, "arr 07:00, dep 07:12"},
+		{"running late", "07:00:00", "07:12:00", Prediction{Known: true, ArrivalDelay: 5 * time.Minute, DepartureDelay: time.Minute},
+			"arr 07:00 → 07:05 (+5 min), dep 07:12 → 07:13 (+1 min)"},
+		{"cancelled", "07:00:00", "07:12:00", Prediction{Known: true, Cancelled: true}, "07:00 cancelled"},
+	}
+	for _, test := range tests {
+		arrival, _ := ParseGTFSTime(test.arrival)
+		departure, _ := ParseGTFSTime(test.departure)
+		stopTime := StopTime{Arrival: arrival, Departure: departure}
+		if got := stopTimeText(stopTime, test.prediction); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -5,26 +5,22 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/padster/triptime/fb"
-
 	ctx "golang.org/x/net/context"
 	// "google.golang.org/appengine/log"
 )
 
-const MAX_BUTTONS = 3 // Bot API limit
-
 // Given a location, tell the times of the next N trains leaving from this station.
-func nextNLeavesAction(c ctx.Context, msg fb.Message, nInput string) fb.OutboundMessage {
+func nextNLeavesAction(c ctx.Context, req Request, nInput string) Reply {
 	var state *UserState
-	var err *fb.OutboundMessage
-	if state, err = NeedUserState(c, msg); err != nil {
+	var err *Reply
+	if state, err = NeedUserState(c, req); err != nil {
 		return *err
 	}
 	stopAt := state.StopAt
 
 	parts := strings.Split(nInput, " ")
 	if len(parts) > 2 {
-		return nextNUsage(msg)
+		return nextNUsage()
 	}
 
 	var n int64
@@ -36,11 +32,11 @@ func nextNLeavesAction(c ctx.Context, msg fb.Message, nInput string) fb.Outbound
 		if len(parts) == 1 {
 			direction = parts[0]
 		} else {
-			return nextNUsage(msg)
+			return nextNUsage()
 		}
 	} else {
 		if n < 1 || n > 8 {
-			return nextNUsage(msg)
+			return nextNUsage()
 		}
 		if len(parts) == 2 {
 			direction = parts[1]
@@ -48,12 +44,12 @@ func nextNLeavesAction(c ctx.Context, msg fb.Message, nInput string) fb.Outbound
 	}
 	direction = strings.ToUpper(direction)
 
-	return nextNLeavesResult(c, msg, stopAt, direction, int(n))
+	return nextNLeavesResult(c, stopAt, direction, int(n))
 }
 
-func nextNLeavesResult(c ctx.Context, msg fb.Message, stopAt Stop, direction string, n int) fb.OutboundMessage {
-	nextTrips := NextNTripsFromStop(stopAt, direction, n)
-	t := getSFTime()
+func nextNLeavesResult(c ctx.Context, stopAt Stop, direction string, n int) Reply {
+	t := DATA.Now()
+	nextTrips, err := DATA.NextNTripsFromStop(stopAt, direction, n, t)
 
 	directionMsg := ""
 	if direction != "" {
@@ -65,38 +61,29 @@ func nextNLeavesResult(c ctx.Context, msg fb.Message, stopAt Stop, direction str
 	}
 
 	text :=
-		fmt.Sprintf("Current time: %s\n", t.Format("15:04")) +
+		fmt.Sprintf("Current time: %s\n", DATA.LocalTime(stopAt, t).Format("15:04")) +
 			fmt.Sprintf("Next %s from %s:\n", nStopMsg, stopAt.Name)
+	text += noMoreTrainsMessage(err, stopAt, direction, t)
 	for _, trip := range nextTrips {
-		routeName := GetRoute(trip.Trip.RouteId).LongName
+		routeName := DATA.RouteLabel(trip.Trip.RouteId)
 		codeMsg := ""
 		if direction == "" {
-			codeMsg = fmt.Sprintf("%s @ ", trip.Stop.PlatCode)
+			codeMsg = fmt.Sprintf("%s @ ", DirectionName(trip.Stop, trip.Trip))
 		}
-		text += fmt.Sprintf(" 🚆 %s%s (%s)\n", codeMsg, trip.StopTime.Arrival, routeName)
+		text += fmt.Sprintf(" 🚆 %s%s (%s)\n", codeMsg, trip.DepartureText(), routeName)
 	}
+	text += alertSummary(DATA.AlertsFor(stopAt, nextTrips, t))
 
-	response := buttonPayload(text)
-	for i, trip := range nextTrips {
-		caption := fmt.Sprintf("View %s @ %s stops", trip.Stop.PlatCode, trip.StopTime.Arrival)
-		payload := strings.Join([]string{"liststops", trip.Stop.StopId, trip.Trip.TripId}, "/")
-		if i < MAX_BUTTONS {
-			response.AddButton(callbackButton(caption, payload))
-		}
-	}
-
-	atch := templateAttachment(response)
-	return fb.OutboundMessage{
-		msg.Sender,
-		outMessageDataFromAttachment(&atch),
+	reply := textReply(text)
+	for _, trip := range nextTrips {
+		caption := fmt.Sprintf("View %s @ %s stops", trip.Stop.PlatCode, trip.StopTime.Departure)
+		reply.AddChoice(caption, listStopsPayload(trip.Stop, trip.Trip, trip.ServiceDate))
 	}
+	return reply
 }
 
-func nextNUsage(msg fb.Message) fb.OutboundMessage {
+func nextNUsage() Reply {
 	usage := "Try in the form: Next [#trains] [NB/SB]\n"
 	usage += "#trains defaults to 2, is at most 8 and omit NB/SB to get both"
-	return fb.OutboundMessage{
-		msg.Sender,
-		outMessageDataFromText(usage),
-	}
+	return textReply(usage)
 }
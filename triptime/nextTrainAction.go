@@ -0,0 +1,79 @@
+package triptime
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/padster/triptime/fb"
+
+	ctx "golang.org/x/net/context"
+)
+
+// Given a location, find the closest station and the next train each way from it.
+func nextTrainAction(c ctx.Context, req Request, pos *fb.Coordinates) Reply {
+	t := DATA.Now()
+
+	closest, found := DATA.ClosestStop(c, pos)
+	if !found {
+		return textReply("Sorry, I don't know of any stations.")
+	}
+	SetUserState(c, req.UserId, UserState{
+		*pos,
+		closest,
+	})
+	closestPos := &fb.Coordinates{closest.Lat, closest.Long}
+	distToClosestKM := CoordDistKM(closestPos, pos)
+	nextTrips, err := DATA.NextTripsAtStop(closest, t)
+
+	text :=
+		fmt.Sprintf("Current time: %s\n", DATA.LocalTime(closest, t).Format("15:04")) +
+			fmt.Sprintf("Closest stop: %s (%0.2fkm away)\n", closest.Name, distToClosestKM)
+	text += noMoreTrainsMessage(err, closest, "", t)
+	for _, trip := range nextTrips {
+		routeName := DATA.RouteLabel(trip.Trip.RouteId)
+		text += fmt.Sprintf(" 🚆 Next %s: %s (%s)\n", DirectionName(trip.Stop, trip.Trip), trip.DepartureText(), routeName)
+	}
+	text += alertSummary(DATA.AlertsFor(closest, nextTrips, t))
+
+	reply := textReply(text)
+	for _, trip := range nextTrips {
+		caption := fmt.Sprintf("View %s stops", DirectionName(trip.Stop, trip.Trip))
+		reply.AddChoice(caption, listStopsPayload(trip.Stop, trip.Trip, trip.ServiceDate))
+	}
+	reply.AddLink("Directions", mapsDirections(pos, closestPos))
+	return reply
+}
+
+// noMoreTrainsMessage explains an empty next-train answer, and when the first train of
+// the next service day is due instead. It's "" when there were trains to show.
+func noMoreTrainsMessage(err error, stop Stop, direction string, t time.Time) string {
+	text := ""
+	switch err {
+	case ErrNoService:
+		text = "There are no trains running today"
+	case ErrNoMoreTrains:
+		text = "No more trains today"
+	default:
+		return ""
+	}
+	first, found := DATA.FirstTripOfNextServiceDay(stop, direction, t)
+	if !found {
+		return text + ".\n"
+	}
+	day := "on " + first.ServiceDate.Format("Monday")
+	if first.ServiceDate.Equal(dateOf(t).AddDate(0, 0, 1)) {
+		day = "tomorrow"
+	}
+	return fmt.Sprintf("%s, the first train %s is at %s (%s).\n",
+		text, day, first.DepartureText(), DirectionName(first.Stop, first.Trip))
+}
+
+func mapsDirections(from *fb.Coordinates, to *fb.Coordinates) string {
+	return fmt.Sprintf(
+		"https://www.google.com.au/maps/dir/%.7f,%.7f/'%.7f,%.7f'",
+		from.Lat, from.Long, to.Lat, to.Long)
+}
+
+func mapsPlace(at *fb.Coordinates) string {
+	return fmt.Sprintf("https://www.google.com.au/maps/place/%.7f,%.7f", at.Lat, at.Long)
+}
@@ -0,0 +1,133 @@
+package triptime
+
+import (
+	"fmt"
+	"strings"
+
+	ctx "golang.org/x/net/context"
+)
+
+// Whether text asks to get somewhere: "from X to Y", or "to Y" from where the user is.
+func isPlanTripRequest(lowerText string) bool {
+	return strings.HasPrefix(lowerText, "from ") || strings.HasPrefix(lowerText, "to ")
+}
+
+// Given two stations, say which trains get from one to the other and when they arrive.
+func planTripAction(c ctx.Context, req Request, lowerText string) Reply {
+	fromText, toText := "", ""
+	if strings.HasPrefix(lowerText, "from ") {
+		parts := strings.SplitN(lowerText[5:], " to ", 2)
+		if len(parts) != 2 {
+			return planTripUsage()
+		}
+		fromText, toText = parts[0], parts[1]
+	} else {
+		toText = lowerText[3:]
+	}
+
+	var from Stop
+	if fromText == "" {
+		var state *UserState
+		var err *Reply
+		if state, err = NeedUserState(c, req); err != nil {
+			return *err
+		}
+		from = state.StopAt
+	} else {
+		var found bool
+		if from, found = DATA.FindStation(fromText); !found {
+			return unknownStationMessage(fromText)
+		}
+	}
+	to, found := DATA.FindStation(toText)
+	if !found {
+		return unknownStationMessage(toText)
+	}
+	if DATA.StationFor(from).StopId == DATA.StationFor(to).StopId {
+		return planTripUsage()
+	}
+
+	t := DATA.Now()
+	itineraries := DATA.PlanJourney(from, to, t)
+
+	text :=
+		fmt.Sprintf("Current time: %s\n", DATA.LocalTime(from, t).Format("15:04")) +
+			fmt.Sprintf("%s → %s:\n", shortStopName(from.Name), shortStopName(to.Name))
+	if len(itineraries) == 0 {
+		text += "Sorry, I can't find a train that gets there in the next day.\n"
+		return textReply(text)
+	}
+
+	reply := Reply{}
+	for _, it := range itineraries {
+		text += formatItinerary(it)
+		first := it.Legs[0]
+		caption := fmt.Sprintf("View %s stops", DATA.LocalTime(first.From, first.Departs).Format("15:04"))
+		reply.AddChoice(caption, listStopsPayload(first.From, first.Trip, first.ServiceDate))
+	}
+	reply.Text = text
+	return reply
+}
+
+// One line for the journey, then one per train if it needs changes.
+func formatItinerary(it Itinerary) string {
+	first, last := it.Legs[0], it.Legs[len(it.Legs)-1]
+	minutes := int(it.Arrives().Sub(it.Departs()).Minutes())
+	detail := DATA.RouteLabel(first.Trip.RouteId)
+	if len(it.Legs) > 1 {
+		detail = fmt.Sprintf("%d changes", len(it.Legs)-1)
+		if len(it.Legs) == 2 {
+			detail = "1 change"
+		}
+	}
+	text := fmt.Sprintf(" 🚆 %s → %s (%d min, %s%s)\n",
+		DATA.LocalTime(first.From, first.Departs).Format("15:04"),
+		DATA.LocalTime(last.To, last.Arrives).Format("15:04"),
+		minutes, detail, lateness(first))
+	if len(it.Legs) == 1 {
+		return text
+	}
+	for i, leg := range it.Legs {
+		if i > 0 {
+			prev := it.Legs[i-1]
+			if DATA.StationFor(prev.To).StopId != DATA.StationFor(leg.From).StopId {
+				walk, _ := DATA.changeTime(prev.To.StopId, leg.From.StopId)
+				text += fmt.Sprintf("    🚶 Walk to %s (%d min)\n", shortStopName(leg.From.Name), int(walk.Minutes()))
+			}
+		}
+		text += fmt.Sprintf("    %s %s → %s %s%s\n",
+			DATA.LocalTime(leg.From, leg.Departs).Format("15:04"),
+			DATA.RouteLabel(leg.Trip.RouteId),
+			shortStopName(leg.To.Name),
+			DATA.LocalTime(leg.To, leg.Arrives).Format("15:04"),
+			lateness(leg))
+	}
+	return text
+}
+
+// lateness notes how far a leg's departure is off the timetable, e.g. ", 12 min late".
+func lateness(leg JourneyLeg) string {
+	stopTime := DATA.TimeForStopAndTrip(leg.From.StopId, leg.Trip.TripId)
+	if stopTime == nil {
+		return ""
+	}
+	scheduled := stopTime.Departure.On(leg.ServiceDate)
+	minutes := int(leg.Departs.Sub(scheduled).Minutes())
+	switch {
+	case minutes > 0:
+		return fmt.Sprintf(", %d min late, due %s", minutes, stopTime.Departure)
+	case minutes < 0:
+		return fmt.Sprintf(", %d min early, due %s", -minutes, stopTime.Departure)
+	}
+	return ""
+}
+
+func unknownStationMessage(name string) Reply {
+	return textReply(fmt.Sprintf("Sorry, I don't know a station called '%s'.", name))
+}
+
+func planTripUsage() Reply {
+	usage := "Try in the form: From [station] to [station]\n"
+	usage += "or just: To [station] to go from the station near you"
+	return textReply(usage)
+}
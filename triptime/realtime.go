@@ -0,0 +1,387 @@
+package triptime
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+
+	ctx "golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+)
+
+const (
+	// How long a poll of the realtime feeds is trusted before asking again.
+	REALTIME_MAX_AGE = 30 * time.Second
+	// How long a poll may take, slower feeds are left out of it.
+	REALTIME_FETCH_TIMEOUT = 5 * time.Second
+)
+
+// Realtime is one poll of the GTFS-Realtime feeds, layered over the static timetable.
+type Realtime struct {
+	Fetched time.Time
+	// TripUpdates by trip ID + "@" + YYYYMMDD service date.
+	trips  map[string]*tripUpdate
+	alerts []ServiceAlert
+	// VehiclePositions, keyed like trips.
+	vehicles map[string]vehiclePosition
+}
+
+type tripUpdate struct {
+	cancelled bool
+	// Trip-wide delay, for stops before the first StopTimeUpdate.
+	hasDelay bool
+	delay    time.Duration
+	// StopTimeUpdates, sorted by stop_sequence.
+	stops []stopUpdate
+}
+
+type stopUpdate struct {
+	seq          int
+	skipped      bool
+	noData       bool
+	hasArrival   bool
+	arrival      time.Duration // Delay.
+	hasDeparture bool
+	departure    time.Duration
+}
+
+// Prediction is what the realtime feed expects of a train at one stop.
+// Known is false when the feed says nothing, and the timetable is all there is.
+type Prediction struct {
+	Known          bool
+	Cancelled      bool // The trip is cancelled, or won't call at this stop.
+	ArrivalDelay   time.Duration
+	DepartureDelay time.Duration
+}
+
+func NewRealtime(fetched time.Time) *Realtime {
+	return &Realtime{fetched, map[string]*tripUpdate{}, []ServiceAlert{}, map[string]vehiclePosition{}}
+}
+
+// Realtime is the latest poll of the realtime feeds, nil until one has been read.
+func (d *GTFSData) Realtime() *Realtime {
+	d.realtimeLock.RLock()
+	defer d.realtimeLock.RUnlock()
+	return d.realtime
+}
+
+func (d *GTFSData) SetRealtime(rt *Realtime) {
+	d.realtimeLock.Lock()
+	defer d.realtimeLock.Unlock()
+	d.realtime = rt
+}
+
+// Predict says how late a trip is at one of its stops. Delays carry on down the
+// line from the last stop the feed mentions, as the GTFS-Realtime spec asks.
+func (rt *Realtime) Predict(trip *Trip, serviceDate time.Time, stopTime *StopTime) Prediction {
+	if rt == nil {
+		return Prediction{}
+	}
+	update := rt.trips[trip.TripId+"@"+dateAsString(serviceDate)]
+	if update == nil {
+		return Prediction{}
+	}
+	if update.cancelled {
+		return Prediction{Known: true, Cancelled: true}
+	}
+
+	p := Prediction{}
+	if update.hasDelay {
+		p = Prediction{Known: true, ArrivalDelay: update.delay, DepartureDelay: update.delay}
+	}
+	for _, su := range update.stops {
+		if su.seq > stopTime.StopSeq {
+			break
+		}
+		switch {
+		case su.noData:
+			p = Prediction{}
+		case su.skipped:
+			if su.seq == stopTime.StopSeq {
+				return Prediction{Known: true, Cancelled: true}
+			}
+		case su.seq == stopTime.StopSeq:
+			p.Known = true
+			if su.hasArrival {
+				p.ArrivalDelay = su.arrival
+			} else if su.hasDeparture {
+				p.ArrivalDelay = su.departure
+			}
+			p.DepartureDelay = p.ArrivalDelay
+			if su.hasDeparture {
+				p.DepartureDelay = su.departure
+			}
+		default:
+			delay := su.arrival
+			if su.hasDeparture {
+				delay = su.departure
+			}
+			p = Prediction{Known: true, ArrivalDelay: delay, DepartureDelay: delay}
+		}
+	}
+	return p
+}
+
+// Describe shows a scheduled time alongside the prediction, e.g. "07:00 → 07:12 (+12 min)".
+func (p Prediction) Describe(scheduled GTFSTime, delay time.Duration) string {
+	switch {
+	case !p.Known:
+		return scheduled.String()
+	case p.Cancelled:
+		return scheduled.String() + " cancelled"
+	}
+	minutes := int(delay.Minutes())
+	if minutes == 0 {
+		return scheduled.String() + " (on time)"
+	}
+	predicted := scheduled + GTFSTime(delay/time.Second)
+	return fmt.Sprintf("%s → %s (%+d min)", scheduled, predicted, minutes)
+}
+
+// AddFeed takes the TripUpdates, Alerts and VehiclePositions from a GTFS-Realtime feed.
+// feedName is the GTFS_FEEDS name its IDs are prefixed with, "" when only one feed is loaded.
+func (rt *Realtime) AddFeed(d *GTFSData, feed *gtfs.FeedMessage, feedName string) {
+	for _, entity := range feed.GetEntity() {
+		if entity.GetIsDeleted() {
+			continue
+		}
+		if update := entity.GetTripUpdate(); update != nil {
+			rt.addTripUpdate(d, update, feedName)
+		}
+		if alert := entity.GetAlert(); alert != nil {
+			rt.addAlert(d, alert, feedName)
+		}
+		if vehicle := entity.GetVehicle(); vehicle != nil {
+			rt.addVehicle(d, vehicle, feedName)
+		}
+	}
+}
+
+func (rt *Realtime) addTripUpdate(d *GTFSData, update *gtfs.TripUpdate, feedName string) {
+	descriptor := update.GetTrip()
+	trip := d.GetTrip(feedId(feedName, descriptor.GetTripId()))
+	if trip == nil {
+		// Added and unscheduled trips aren't in the timetable to adjust.
+		return
+	}
+	serviceDate, ok := d.realtimeServiceDate(trip, descriptor.GetStartDate(), rt.Fetched)
+	if !ok {
+		return
+	}
+
+	tu := &tripUpdate{}
+	switch descriptor.GetScheduleRelationship() {
+	case gtfs.TripDescriptor_CANCELED, gtfs.TripDescriptor_DELETED:
+		tu.cancelled = true
+	}
+	if update.Delay != nil {
+		tu.hasDelay = true
+		tu.delay = time.Duration(update.GetDelay()) * time.Second
+	}
+	stopTimes := d.SortedStopTimesForTrip(trip.TripId)
+	for _, stu := range update.GetStopTimeUpdate() {
+		stopTime := findRealtimeStopTime(stopTimes, stu, feedId(feedName, stu.GetStopId()))
+		if stopTime == nil {
+			continue
+		}
+		su := stopUpdate{seq: stopTime.StopSeq}
+		switch stu.GetScheduleRelationship() {
+		case gtfs.TripUpdate_StopTimeUpdate_SKIPPED:
+			su.skipped = true
+		case gtfs.TripUpdate_StopTimeUpdate_NO_DATA:
+			su.noData = true
+		}
+		su.arrival, su.hasArrival = eventDelay(stu.GetArrival(), stopTime.Arrival.On(serviceDate))
+		su.departure, su.hasDeparture = eventDelay(stu.GetDeparture(), stopTime.Departure.On(serviceDate))
+		tu.stops = append(tu.stops, su)
+	}
+	sort.Sort(stopUpdatesBySequence(tu.stops))
+	rt.trips[trip.TripId+"@"+dateAsString(serviceDate)] = tu
+}
+
+// feedId is a realtime feed's ID as the merged static feeds know it.
+func feedId(feedName string, value string) string {
+	if feedName == "" || value == "" {
+		return value
+	}
+	return feedName + ":" + value
+}
+
+// findRealtimeStopTime matches a StopTimeUpdate to the trip's stop, by stop_sequence if given.
+func findRealtimeStopTime(stopTimes []StopTime, stu *gtfs.TripUpdate_StopTimeUpdate, stopId string) *StopTime {
+	for i := range stopTimes {
+		if stu.StopSequence != nil {
+			if stopTimes[i].StopSeq == int(stu.GetStopSequence()) {
+				return &stopTimes[i]
+			}
+		} else if stopTimes[i].StopId == stopId {
+			return &stopTimes[i]
+		}
+	}
+	return nil
+}
+
+// eventDelay is how late an arrival or departure is, whether the feed gives a delay or a time.
+func eventDelay(event *gtfs.TripUpdate_StopTimeEvent, scheduled time.Time) (time.Duration, bool) {
+	switch {
+	case event == nil:
+		return 0, false
+	case event.Delay != nil:
+		return time.Duration(event.GetDelay()) * time.Second, true
+	case event.Time != nil:
+		return time.Unix(event.GetTime(), 0).Sub(scheduled), true
+	}
+	return 0, false
+}
+
+// realtimeServiceDate is the day a TripUpdate is for: its start_date, or when that's
+// missing, whichever of yesterday and today has the trip running around now.
+func (d *GTFSData) realtimeServiceDate(trip *Trip, startDate string, now time.Time) (time.Time, bool) {
+	if startDate != "" {
+		date, err := time.ParseInLocation("20060102", startDate, d.Location)
+		return date, err == nil
+	}
+	stopTimes := d.SortedStopTimesForTrip(trip.TripId)
+	if len(stopTimes) == 0 {
+		return time.Time{}, false
+	}
+	today := dateOf(now.In(d.Location))
+	for _, date := range []time.Time{today.AddDate(0, 0, -1), today} {
+		if !d.ActiveServices(date)[trip.ServiceId] {
+			continue
+		}
+		// Allow an hour either side for the train running early or late.
+		starts := stopTimes[0].Departure.On(date).Add(-time.Hour)
+		ends := stopTimes[len(stopTimes)-1].Arrival.On(date).Add(time.Hour)
+		if !now.Before(starts) && now.Before(ends) {
+			return date, true
+		}
+	}
+	return today, true
+}
+
+// realtimeFeeds reads GTFS_RT_FEEDS from app.yaml, e.g. "caltrain=https://...,bart=https://...",
+// naming the GTFS_FEEDS feed each is for, falling back to the single feed at GTFS_RT_URL.
+// A feed may be listed more than once, and any entry may be a local file instead.
+func realtimeFeeds() []FeedConfig {
+	list := os.Getenv("GTFS_RT_FEEDS")
+	if list == "" {
+		if url := os.Getenv("GTFS_RT_URL"); url != "" {
+			return []FeedConfig{{"", url}}
+		}
+		return []FeedConfig{}
+	}
+	feeds := parseFeedList(list)
+	if len(gtfsFeeds()) == 1 {
+		// A lone static feed isn't namespaced.
+		for i := range feeds {
+			feeds[i].Name = ""
+		}
+	}
+	return feeds
+}
+
+var (
+	realtimeLock sync.Mutex
+	// Set while a request polls the feeds, so others carry on with the last poll.
+	realtimeRefreshing bool
+)
+
+// refreshRealtime polls the realtime feeds if the last poll is older than
+// REALTIME_MAX_AGE, all at once and without holding up other requests. Feeds
+// that can't be read in time are logged and skipped.
+func refreshRealtime(c ctx.Context) {
+	configs := realtimeFeeds()
+	if len(configs) == 0 {
+		return
+	}
+	data, now := DATA, CLOCK.Now()
+	realtimeLock.Lock()
+	last := data.Realtime()
+	if realtimeRefreshing || (last != nil && now.Sub(last.Fetched) < REALTIME_MAX_AGE) {
+		realtimeLock.Unlock()
+		return
+	}
+	realtimeRefreshing = true
+	realtimeLock.Unlock()
+	defer func() {
+		realtimeLock.Lock()
+		realtimeRefreshing = false
+		realtimeLock.Unlock()
+	}()
+
+	fetchCtx, cancel := ctx.WithTimeout(c, REALTIME_FETCH_TIMEOUT)
+	defer cancel()
+	feeds := make([]*gtfs.FeedMessage, len(configs))
+	var wg sync.WaitGroup
+	for i, config := range configs {
+		wg.Add(1)
+		go func(i int, config FeedConfig) {
+			defer wg.Done()
+			feed, err := readRealtimeFeed(fetchCtx, config.Path)
+			if err != nil {
+				log.Warningf(c, "Can't read realtime feed %s: %v", config.Path, err)
+				return
+			}
+			feeds[i] = feed
+		}(i, config)
+	}
+	wg.Wait()
+
+	rt := NewRealtime(now)
+	for i, feed := range feeds {
+		if feed != nil {
+			rt.AddFeed(data, feed, configs[i].Name)
+		}
+	}
+	data.SetRealtime(rt)
+}
+
+// readRealtimeFeed fetches a GTFS-Realtime protobuf from a URL, or reads it from a file.
+func readRealtimeFeed(c ctx.Context, source string) (*gtfs.FeedMessage, error) {
+	var body []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		var r *http.Response
+		if r, err = urlfetch.Client(c).Get(source); err != nil {
+			return nil, err
+		}
+		defer r.Body.Close()
+		if r.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetch failed: %s", r.Status)
+		}
+		body, err = ioutil.ReadAll(r.Body)
+	} else {
+		body, err = ioutil.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &gtfs.FeedMessage{}
+	if err := proto.Unmarshal(body, feed); err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+type stopUpdatesBySequence []stopUpdate
+
+func (sus stopUpdatesBySequence) Len() int {
+	return len(sus)
+}
+func (sus stopUpdatesBySequence) Swap(i, j int) {
+	sus[i], sus[j] = sus[j], sus[i]
+}
+func (sus stopUpdatesBySequence) Less(i, j int) bool {
+	return sus[i].seq < sus[j].seq
+}
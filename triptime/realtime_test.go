@@ -0,0 +1,166 @@
+package triptime
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	ctx "golang.org/x/net/context"
+)
+
+// Trip "day" runs San Jose to San Francisco in the morning, "owl" leaves at
+// 23:50 and gets in after midnight.
+func realtimeTestFeed(t *testing.T) *GTFSData {
+	d, err := LoadGTFS(memFeed{
+		"agency.txt":   "agency_id,agency_name,agency_url,agency_timezone\nCT,Caltrain,http://caltrain.com,America/Los_Angeles\n",
+		"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nD,1,1,1,1,1,1,1,20260101,20261231\n",
+		"routes.txt":   "route_id,route_type\nL1,2\n",
+		"stops.txt":    "stop_id,stop_name,stop_lat,stop_lon\nsj,San Jose,37.33,-121.90\npa,Palo Alto,37.44,-122.16\nsf,San Francisco,37.77,-122.39\n",
+		"trips.txt":    "route_id,service_id,trip_id\nL1,D,day\nL1,D,owl\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"day,07:00:00,07:00:00,sj,1\nday,07:20:00,07:22:00,pa,2\nday,08:00:00,08:00:00,sf,3\n" +
+			"owl,23:50:00,23:50:00,sj,1\nowl,24:10:00,24:10:00,pa,2\nowl,24:50:00,24:50:00,sf,3\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func int32p(v int32) *int32 { return &v }
+
+func uint32p(v uint32) *uint32 { return &v }
+
+func delayAt(seq uint32, arrival *int32, departure *int32) *gtfs.TripUpdate_StopTimeUpdate {
+	stu := &gtfs.TripUpdate_StopTimeUpdate{StopSequence: uint32p(seq)}
+	if arrival != nil {
+		stu.Arrival = &gtfs.TripUpdate_StopTimeEvent{Delay: arrival}
+	}
+	if departure != nil {
+		stu.Departure = &gtfs.TripUpdate_StopTimeEvent{Delay: departure}
+	}
+	return stu
+}
+
+func TestPredict(t *testing.T) {
+	d := realtimeTestFeed(t)
+	tripId, startDate := "day", "20261021"
+	skipped := gtfs.TripUpdate_StopTimeUpdate_SKIPPED
+	noData := gtfs.TripUpdate_StopTimeUpdate_NO_DATA
+	cancelled := gtfs.TripDescriptor_CANCELED
+	paloAlto := "pa"
+	arrivesSF := at(t, d, "2026-10-21 08:03").Unix()
+
+	tests := []struct {
+		name   string
+		update *gtfs.TripUpdate
+		want   []string // Arrival and departure at each stop.
+	}{
+		{"no update", nil,
+			[]string{"07:00 07:00", "07:20 07:22", "08:00 08:00"}},
+		{"trip delay", &gtfs.TripUpdate{Delay: int32p(120)},
+			[]string{"07:00 → 07:02 (+2 min) 07:00 → 07:02 (+2 min)", "07:20 → 07:22 (+2 min) 07:22 → 07:24 (+2 min)", "08:00 → 08:02 (+2 min) 08:00 → 08:02 (+2 min)"}},
+		{"delay carries on down the line", &gtfs.TripUpdate{StopTimeUpdate: []*gtfs.TripUpdate_StopTimeUpdate{
+			delayAt(2, int32p(300), int32p(180))}},
+			[]string{"07:00 07:00", "07:20 → 07:25 (+5 min) 07:22 → 07:25 (+3 min)", "08:00 → 08:03 (+3 min) 08:00 → 08:03 (+3 min)"}},
+		{"matched by stop ID", &gtfs.TripUpdate{StopTimeUpdate: []*gtfs.TripUpdate_StopTimeUpdate{
+			{StopId: &paloAlto, Arrival: &gtfs.TripUpdate_StopTimeEvent{Delay: int32p(60)}}}},
+			[]string{"07:00 07:00", "07:20 → 07:21 (+1 min) 07:22 → 07:23 (+1 min)", "08:00 → 08:01 (+1 min) 08:00 → 08:01 (+1 min)"}},
+		{"time rather than delay", &gtfs.TripUpdate{StopTimeUpdate: []*gtfs.TripUpdate_StopTimeUpdate{
+			{StopSequence: uint32p(3), Arrival: &gtfs.TripUpdate_StopTimeEvent{Time: &arrivesSF}}}},
+			[]string{"07:00 07:00", "07:20 07:22", "08:00 → 08:03 (+3 min) 08:00 → 08:03 (+3 min)"}},
+		{"skipped stop", &gtfs.TripUpdate{StopTimeUpdate: []*gtfs.TripUpdate_StopTimeUpdate{
+			delayAt(1, nil, int32p(60)),
+			{StopSequence: uint32p(2), ScheduleRelationship: &skipped}}},
+			[]string{"07:00 → 07:01 (+1 min) 07:00 → 07:01 (+1 min)", "07:20 cancelled 07:22 cancelled", "08:00 → 08:01 (+1 min) 08:00 → 08:01 (+1 min)"}},
+		{"no data from a stop on", &gtfs.TripUpdate{StopTimeUpdate: []*gtfs.TripUpdate_StopTimeUpdate{
+			delayAt(1, nil, int32p(60)),
+			{StopSequence: uint32p(2), ScheduleRelationship: &noData}}},
+			[]string{"07:00 → 07:01 (+1 min) 07:00 → 07:01 (+1 min)", "07:20 07:22", "08:00 08:00"}},
+		{"cancelled trip", &gtfs.TripUpdate{Trip: &gtfs.TripDescriptor{ScheduleRelationship: &cancelled}},
+			[]string{"07:00 cancelled 07:00 cancelled", "07:20 cancelled 07:22 cancelled", "08:00 cancelled 08:00 cancelled"}},
+	}
+	for _, test := range tests {
+		rt := NewRealtime(at(t, d, "2026-10-21 06:55"))
+		if test.update != nil {
+			if test.update.Trip == nil {
+				test.update.Trip = &gtfs.TripDescriptor{}
+			}
+			test.update.Trip.TripId, test.update.Trip.StartDate = &tripId, &startDate
+			rt.AddFeed(d, &gtfs.FeedMessage{Entity: []*gtfs.FeedEntity{{TripUpdate: test.update}}}, "")
+		}
+		trip := d.GetTrip("day")
+		date := dateOf(at(t, d, "2026-10-21 12:00"))
+		for i, stopTime := range d.SortedStopTimesForTrip("day") {
+			p := rt.Predict(trip, date, &stopTime)
+			got := p.Describe(stopTime.Arrival, p.ArrivalDelay) + " " + p.Describe(stopTime.Departure, p.DepartureDelay)
+			if got != test.want[i] {
+				t.Errorf("%s, stop %d: got %q, want %q", test.name, stopTime.StopSeq, got, test.want[i])
+			}
+		}
+	}
+}
+
+func TestRealtimeServiceDate(t *testing.T) {
+	d := realtimeTestFeed(t)
+	tests := []struct {
+		name      string
+		trip      string
+		startDate string
+		now       string
+		want      string
+	}{
+		{"start date given", "day", "20261020", "2026-10-21 07:10", "2026-10-20"},
+		{"morning train", "day", "", "2026-10-21 07:10", "2026-10-21"},
+		{"owl before midnight", "owl", "", "2026-10-21 23:55", "2026-10-21"},
+		{"owl after midnight", "owl", "", "2026-10-22 00:20", "2026-10-21"},
+		{"owl running late", "owl", "", "2026-10-22 01:30", "2026-10-21"},
+		{"owl long gone", "owl", "", "2026-10-22 06:00", "2026-10-22"},
+	}
+	for _, test := range tests {
+		date, ok := d.realtimeServiceDate(d.GetTrip(test.trip), test.startDate, at(t, d, test.now))
+		if got := date.Format("2006-01-02"); !ok || got != test.want {
+			t.Errorf("%s: got %s (%v), want %s", test.name, got, ok, test.want)
+		}
+	}
+
+	// Without a start date, the update applies to the owl that's out after midnight.
+	tripId := "owl"
+	rt := NewRealtime(at(t, d, "2026-10-22 00:20"))
+	rt.AddFeed(d, &gtfs.FeedMessage{Entity: []*gtfs.FeedEntity{{TripUpdate: &gtfs.TripUpdate{
+		Trip: &gtfs.TripDescriptor{TripId: &tripId}, Delay: int32p(600),
+	}}}}, "")
+	stopTime := d.TimeForStopAndTrip("sf", "owl")
+	if p := rt.Predict(d.GetTrip("owl"), dateOf(at(t, d, "2026-10-21 12:00")), stopTime); p.ArrivalDelay != 10*time.Minute {
+		t.Errorf("yesterday's owl: got %+v, want 10 minutes late", p)
+	}
+	if p := rt.Predict(d.GetTrip("owl"), dateOf(at(t, d, "2026-10-22 12:00")), stopTime); p.Known {
+		t.Errorf("today's owl: got %+v, want no prediction", p)
+	}
+}
+
+func TestRefreshRealtimeDoesNotWait(t *testing.T) {
+	saved := DATA
+	DATA = realtimeTestFeed(t)
+	defer func() { DATA = saved }()
+	os.Setenv("GTFS_RT_URL", "no-such-feed.pb")
+	defer os.Unsetenv("GTFS_RT_URL")
+
+	// Another request is already polling, so this one goes ahead without.
+	realtimeRefreshing = true
+	defer func() { realtimeRefreshing = false }()
+	done := make(chan bool)
+	go func() {
+		refreshRealtime(ctx.Background())
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("refreshRealtime waited for the other poll")
+	}
+	if DATA.Realtime() != nil {
+		t.Errorf("got a poll, want none")
+	}
+}
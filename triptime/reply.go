@@ -0,0 +1,113 @@
+package triptime
+
+import (
+	"strings"
+
+	"github.com/padster/triptime/fb"
+
+	ctx "golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+)
+
+// Request is a message from a rider, whichever platform it came in on.
+type Request struct {
+	UserId   string
+	Text     string
+	Location *fb.Coordinates // A shared location, rather than text.
+	Postback string          // Payload of a Choice they picked.
+}
+
+// Reply is what the bot says back, for each platform's adapter to render as best it can.
+type Reply struct {
+	Text     string
+	Choices  []Choice // Follow-ups the rider can pick, which come back as a Postback.
+	Links    []Link
+	Location *Place // Somewhere to show on a map.
+}
+
+type Choice struct {
+	Caption string
+	Payload string
+}
+
+type Link struct {
+	Caption string
+	URL     string
+}
+
+type Place struct {
+	Name string
+	At   fb.Coordinates
+}
+
+func textReply(text string) Reply {
+	return Reply{Text: text}
+}
+
+func (r *Reply) AddChoice(caption string, payload string) {
+	r.Choices = append(r.Choices, Choice{caption, payload})
+}
+
+func (r *Reply) AddLink(caption string, url string) {
+	r.Links = append(r.Links, Link{caption, url})
+}
+
+// respond works out what to say to a request, usually one reply but
+// sometimes several, e.g. a long list of stops.
+func respond(c ctx.Context, req Request) []Reply {
+	if DATA == nil {
+		log.Errorf(c, "No timetable loaded: %v", DATA_ERR)
+		return []Reply{textReply("Sorry, I can't read the timetable right now ☹ - please try again later.")}
+	}
+	refreshRealtime(c)
+
+	if req.Postback != "" {
+		log.Infof(c, "RECV pb: %s", req.Postback)
+		parts := strings.Split(req.Postback, "/")
+		switch {
+		case parts[0] == "liststops" && len(parts) >= 3:
+			serviceDate := ""
+			if len(parts) > 3 {
+				serviceDate = parts[3]
+			}
+			return listStopsAction(c, req, parts[1], parts[2], serviceDate)
+		case parts[0] == "whereis" && len(parts) == 3:
+			return []Reply{whereIsPostback(c, req, parts[1], parts[2])}
+		}
+		return []Reply{textReply("Ummm...I'm confused?")}
+	}
+
+	if req.Location != nil {
+		return []Reply{nextTrainAction(c, req, req.Location)}
+	}
+	lowerText := strings.ToLower(strings.TrimSpace(req.Text))
+	if lowerText == "help" || lowerText == "commands" {
+		return []Reply{helpAction(c, req)}
+	}
+	if lowerText == "alerts" {
+		return []Reply{alertsAction(c, req)}
+	}
+	if strings.HasPrefix(lowerText, "next") {
+		return []Reply{nextNLeavesAction(c, req, strings.TrimSpace(lowerText[4:]))}
+	}
+	if isPlanTripRequest(lowerText) {
+		return []Reply{planTripAction(c, req, lowerText)}
+	}
+	if isArriveByRequest(lowerText) {
+		return []Reply{arriveByAction(c, req, lowerText)}
+	}
+	if isWhereIsRequest(lowerText) {
+		return []Reply{whereIsAction(c, req, lowerText)}
+	}
+	if cannedResponse := cannedResponseAction(c, req, lowerText); cannedResponse != nil {
+		return []Reply{*cannedResponse}
+	}
+	if station, found := DATA.FindStation(lowerText); found && normalizeStationName(station.Name) == normalizeStationName(lowerText) {
+		// Just a station's name, so no need to geocode it.
+		return []Reply{nextTrainAction(c, req, &fb.Coordinates{station.Lat, station.Long})}
+	}
+	if posFromText := maybeTextToPosition(c, req, lowerText); posFromText != nil {
+		return []Reply{nextTrainAction(c, req, posFromText)}
+	}
+	return []Reply{helpAction(c, req)}
+}
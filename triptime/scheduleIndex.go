@@ -0,0 +1,85 @@
+package triptime
+
+import (
+	"sort"
+)
+
+// Lookup tables over GTFSData, built once at load time so queries don't rescan the feed.
+type scheduleIndex struct {
+	agencies       map[string]*Agency
+	stops          map[string]*Stop
+	routes         map[string]*Route
+	trips          map[string]*Trip
+	tripsByService map[string][]*Trip
+	// Platforms (location_type 0) by their parent station's ID.
+	platformsByStation map[string][]*Stop
+	// Each trip's stop times, sorted by stop_sequence.
+	stopTimesByTrip map[string][]StopTime
+	// The same stop times by the stop they're at, so next-train queries only see trains calling there.
+	stopTimesByStop map[string][]*StopTime
+	// calendar_dates.txt exceptions, keyed by YYYYMMDD date.
+	exceptionsByDate map[string][]ServiceDateException
+	// Where passengers can change trains, by the platform they arrive at / leave from.
+	changesFrom map[string][]change
+	changesTo   map[string][]change
+}
+
+func (d *GTFSData) buildIndex() {
+	idx := scheduleIndex{
+		agencies:           map[string]*Agency{},
+		stops:              map[string]*Stop{},
+		routes:             map[string]*Route{},
+		trips:              map[string]*Trip{},
+		tripsByService:     map[string][]*Trip{},
+		platformsByStation: map[string][]*Stop{},
+		stopTimesByTrip:    map[string][]StopTime{},
+		stopTimesByStop:    map[string][]*StopTime{},
+		exceptionsByDate:   map[string][]ServiceDateException{},
+	}
+	for i, agency := range d.Agencies {
+		idx.agencies[agency.AgencyId] = &d.Agencies[i]
+	}
+	for i, stop := range d.Stops {
+		idx.stops[stop.StopId] = &d.Stops[i]
+		if stop.Type == LOCATION_STOP && stop.Parent != "" {
+			idx.platformsByStation[stop.Parent] = append(idx.platformsByStation[stop.Parent], &d.Stops[i])
+		}
+	}
+	for i, route := range d.Routes {
+		idx.routes[route.RouteId] = &d.Routes[i]
+	}
+	for i, trip := range d.Trips {
+		idx.trips[trip.TripId] = &d.Trips[i]
+		idx.tripsByService[trip.ServiceId] = append(idx.tripsByService[trip.ServiceId], &d.Trips[i])
+	}
+	for _, stopTime := range d.StopTimes {
+		idx.stopTimesByTrip[stopTime.TripId] = append(idx.stopTimesByTrip[stopTime.TripId], stopTime)
+	}
+	for _, stopTimes := range idx.stopTimesByTrip {
+		sort.Sort(TimesBySequence(stopTimes))
+		for i := range stopTimes {
+			idx.stopTimesByStop[stopTimes[i].StopId] = append(idx.stopTimesByStop[stopTimes[i].StopId], &stopTimes[i])
+		}
+	}
+	for _, ex := range d.ServiceDateExceptions {
+		idx.exceptionsByDate[ex.Date] = append(idx.exceptionsByDate[ex.Date], ex)
+	}
+	d.index = idx
+	d.buildChanges()
+}
+
+func (d *GTFSData) GetTrip(tripId string) *Trip {
+	return d.index.trips[tripId]
+}
+
+type TimesBySequence []StopTime
+
+func (times TimesBySequence) Len() int {
+	return len(times)
+}
+func (times TimesBySequence) Swap(i, j int) {
+	times[i], times[j] = times[j], times[i]
+}
+func (times TimesBySequence) Less(i, j int) bool {
+	return times[i].StopSeq < times[j].StopSeq
+}
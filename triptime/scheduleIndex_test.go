@@ -0,0 +1,180 @@
+package triptime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchmarkFeed is the deployed feed (the Caltrain one, in gtfs/) if it loaded,
+// otherwise one the same shape: 29 stations and 104 weekday trains calling at each.
+func benchmarkFeed(b testing.TB) *GTFSData {
+	if DATA != nil {
+		return DATA
+	}
+	stops := []string{"stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station,platform_code"}
+	for i := 0; i < 29; i++ {
+		lat := 37.3 + float64(i)*0.02
+		stops = append(stops,
+			fmt.Sprintf("s%d,Station %d Caltrain,%f,-122.2,1,,", i, i, lat),
+			fmt.Sprintf("s%dn,Station %d Caltrain,%f,-122.2,0,s%d,NB", i, i, lat, i),
+			fmt.Sprintf("s%ds,Station %d Caltrain,%f,-122.2,0,s%d,SB", i, i, lat, i))
+	}
+	trips := []string{"route_id,service_id,trip_id"}
+	stopTimes := []string{"trip_id,arrival_time,departure_time,stop_id,stop_sequence"}
+	for trip := 0; trip < 104; trip++ {
+		trips = append(trips, fmt.Sprintf("L1,WD,%d", trip))
+		start := 5*3600 + (trip/2)*15*60
+		for seq := 0; seq < 29; seq++ {
+			stop := fmt.Sprintf("s%dn", seq)
+			if trip%2 == 1 {
+				stop = fmt.Sprintf("s%ds", 28-seq)
+			}
+			t := GTFSTime(start + seq*3*60)
+			stopTimes = append(stopTimes, fmt.Sprintf("%d,%s:00,%s:00,%s,%d", trip, t, t, stop, seq+1))
+		}
+	}
+	return loadFeed(b, TEST_FEED, memFeed{
+		"stops.txt":      strings.Join(stops, "\n") + "\n",
+		"trips.txt":      strings.Join(trips, "\n") + "\n",
+		"stop_times.txt": strings.Join(stopTimes, "\n") + "\n",
+	})
+}
+
+// linearNextStopTime is NextStopTime as it was before stop times were indexed by stop:
+// every trip running that day, each looked for in the whole stop_times.txt. Kept as the
+// baseline for the benchmarks below.
+func linearNextStopTime(d *GTFSData, stopAt Stop, days []ServiceDay, t time.Time) NextTripResult {
+	best := NextTripResult{Stop: stopAt}
+	rt := d.Realtime()
+	for _, day := range days {
+		for _, trip := range day.Trips {
+			for i := range d.StopTimes {
+				stopTime := &d.StopTimes[i]
+				if stopTime.TripId != trip.TripId || stopTime.StopId != stopAt.StopId {
+					continue
+				}
+				if d.canBoard(stopTime) {
+					curr := NextTripResult{stopTime, stopAt, trip, day.Date, rt.Predict(trip, day.Date, stopTime)}
+					if isBetterStop(curr, best, t) {
+						best = curr
+					}
+				}
+				break
+			}
+		}
+	}
+	return best
+}
+
+// linearNextNTripsFromStop is the baseline for NextNTripsFromStop, scanning the same way.
+func linearNextNTripsFromStop(d *GTFSData, at Stop, direction string, n int, t time.Time) []NextTripResult {
+	days := d.ServiceDaysAround(t)
+	rt := d.Realtime()
+	best := []NextTripResult{}
+	for _, stopAt := range d.DirectionalStops(at, direction) {
+		for _, day := range days {
+			for _, trip := range day.Trips {
+				for i := range d.StopTimes {
+					stopTime := &d.StopTimes[i]
+					if stopTime.TripId != trip.TripId || stopTime.StopId != stopAt.StopId {
+						continue
+					}
+					curr := NextTripResult{stopTime, stopAt, trip, day.Date, rt.Predict(trip, day.Date, stopTime)}
+					if d.canBoard(stopTime) && isBetterStop(curr, NextTripResult{}, t) {
+						best = append(best, curr)
+					}
+					break
+				}
+			}
+		}
+	}
+	sort.SliceStable(best, func(i, j int) bool { return isBetterStop(best[i], best[j], t) })
+	if len(best) > n {
+		best = best[:n]
+	}
+	return best
+}
+
+// The index holds its own copies of the stop times, so compare them by value.
+func sameStopTime(a *StopTime, b *StopTime) bool {
+	return a == b || (a != nil && b != nil && *a == *b)
+}
+
+func TestIndexMatchesLinearScan(t *testing.T) {
+	d := benchmarkFeed(t)
+	for _, station := range []string{"s0", "s14", "s28"} {
+		stop := *d.GetStop(station)
+		for _, when := range []string{"2026-10-21 04:00", "2026-10-21 08:07", "2026-10-21 23:00"} {
+			now := at(t, d, when)
+			days := d.ServiceDaysAround(now)
+			for _, platform := range d.DirectionalStops(stop, "") {
+				got, want := d.NextStopTime(platform, days, now), linearNextStopTime(d, platform, days, now)
+				if !sameStopTime(got.StopTime, want.StopTime) {
+					t.Errorf("%s at %s: next train %+v, linear scan %+v", platform.StopId, when, got.StopTime, want.StopTime)
+				}
+			}
+			got, _ := d.NextNTripsFromStop(stop, "NB", 5, now)
+			want := linearNextNTripsFromStop(d, stop, "NB", 5, now)
+			if len(got) != len(want) {
+				t.Errorf("%s at %s: got %d trains, linear scan %d", station, when, len(got), len(want))
+				continue
+			}
+			for i := range got {
+				if !sameStopTime(got[i].StopTime, want[i].StopTime) {
+					t.Errorf("%s at %s: train %d is %+v, linear scan %+v", station, when, i, got[i].StopTime, want[i].StopTime)
+				}
+			}
+		}
+	}
+}
+
+// A weekday morning at a station part way down the line.
+func benchmarkQuery(b *testing.B) (*GTFSData, Stop) {
+	d := benchmarkFeed(b)
+	stations := []Stop{}
+	for _, stop := range d.Stops {
+		if stop.IsStation() && len(d.DirectionalStops(stop, "")) > 0 {
+			stations = append(stations, stop)
+		}
+	}
+	b.ResetTimer()
+	return d, stations[len(stations)/2]
+}
+
+func BenchmarkNextTripsAtStop(b *testing.B) {
+	d, station := benchmarkQuery(b)
+	t := at(b, d, "2026-10-21 08:00")
+	for i := 0; i < b.N; i++ {
+		d.NextTripsAtStop(station, t)
+	}
+}
+
+func BenchmarkNextTripsAtStopLinear(b *testing.B) {
+	d, station := benchmarkQuery(b)
+	t := at(b, d, "2026-10-21 08:00")
+	for i := 0; i < b.N; i++ {
+		days := d.ServiceDaysAround(t)
+		for _, platform := range d.DirectionalStops(station, "") {
+			linearNextStopTime(d, platform, days, t)
+		}
+	}
+}
+
+func BenchmarkNextNTripsFromStop(b *testing.B) {
+	d, station := benchmarkQuery(b)
+	t := at(b, d, "2026-10-21 08:00")
+	for i := 0; i < b.N; i++ {
+		d.NextNTripsFromStop(station, "NB", 5, t)
+	}
+}
+
+func BenchmarkNextNTripsFromStopLinear(b *testing.B) {
+	d, station := benchmarkQuery(b)
+	t := at(b, d, "2026-10-21 08:00")
+	for i := 0; i < b.N; i++ {
+		linearNextNTripsFromStop(d, station, "NB", 5, t)
+	}
+}
@@ -0,0 +1,56 @@
+package triptime
+
+import (
+	"time"
+)
+
+// calendar_dates.txt exception_type values.
+const (
+	SERVICE_ADDED   = 1
+	SERVICE_REMOVED = 2
+)
+
+// ActiveServices works out which service IDs run on t's date: calendar.txt entries
+// whose date range and weekday cover it, plus added and minus removed exceptions.
+func (d *GTFSData) ActiveServices(t time.Time) map[string]bool {
+	asDate := dateAsString(t)
+	active := map[string]bool{}
+	for _, sd := range d.ServiceDates {
+		// YYYYMMDD compares correctly as a string.
+		if asDate < sd.StartDate || asDate > sd.EndDate {
+			continue
+		}
+		if sd.RunsOn(t.Weekday()) {
+			active[sd.ServiceId] = true
+		}
+	}
+	for _, ex := range d.index.exceptionsByDate[asDate] {
+		switch ex.ExceptionType {
+		case SERVICE_ADDED:
+			active[ex.ServiceId] = true
+		case SERVICE_REMOVED:
+			delete(active, ex.ServiceId)
+		}
+	}
+	return active
+}
+
+func (sd ServiceDate) RunsOn(day time.Weekday) bool {
+	switch day {
+	case time.Monday:
+		return sd.OnMon
+	case time.Tuesday:
+		return sd.OnTue
+	case time.Wednesday:
+		return sd.OnWed
+	case time.Thursday:
+		return sd.OnThu
+	case time.Friday:
+		return sd.OnFri
+	case time.Saturday:
+		return sd.OnSat
+	case time.Sunday:
+		return sd.OnSun
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package triptime
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActiveServices(t *testing.T) {
+	d := &GTFSData{
+		ServiceDates: []ServiceDate{
+			{"WD", true, true, true, true, true, false, false, "20261001", "20261231"},
+			{"WE", false, false, false, false, false, true, true, "20261001", "20261231"},
+			// The winter timetable takes over from January.
+			{"WINTER", true, true, true, true, true, false, false, "20270101", "20270331"},
+		},
+		ServiceDateExceptions: []ServiceDateException{
+			// Thanksgiving runs the weekend timetable instead.
+			{"WD", "20261126", SERVICE_REMOVED},
+			{"WE", "20261126", SERVICE_ADDED},
+			// A game day adds extra trains on top of the usual Saturday.
+			{"GAME", "20261128", SERVICE_ADDED},
+		},
+	}
+	d.buildIndex()
+
+	tests := []struct {
+		name string
+		date string
+		want string // Service IDs, sorted.
+	}{
+		{"weekday", "2026-10-21", "WD"},
+		{"weekend", "2026-10-24", "WE"},
+		{"first day", "2026-10-01", "WD"},
+		{"last day", "2026-12-31", "WD"},
+		{"before the range", "2026-09-30", ""},
+		{"between ranges", "2027-01-02", ""},
+		{"next range", "2027-01-04", "WINTER"},
+		{"thanksgiving", "2026-11-26", "WE"},
+		{"day after thanksgiving", "2026-11-27", "WD"},
+		{"game day", "2026-11-28", "GAME,WE"},
+	}
+	for _, test := range tests {
+		date, err := time.Parse("2006-01-02", test.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for serviceId := range d.ActiveServices(date) {
+			got = append(got, serviceId)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != test.want {
+			t.Errorf("%s (%s): got %v, want %s", test.name, test.date, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package triptime
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/padster/triptime/fb"
+	"github.com/padster/triptime/slack"
+
+	ctx "golang.org/x/net/context"
+	gae "google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+)
+
+func init() {
+	http.HandleFunc("/_/slack/command", slackCommandHandler)
+	http.HandleFunc("/_/slack/actions", slackActionsHandler)
+}
+
+// What's saved for a Slack workspace, keyed by its team ID. It's kept until the
+// team changes it, as the privacy policy says.
+type slackTeam struct {
+	OfficeStopId string
+}
+
+// Slack gives up on a request after 3 seconds, longer than geocoding or polling the
+// realtime feeds can take, so requests are acknowledged straight away and answered
+// from a task through their response_url.
+var (
+	slackCommandLater = delay.Func("slackCommand", slackCommandReply)
+	slackActionLater  = delay.Func("slackAction", slackActionReply)
+)
+
+// Slash command, e.g. /caltrain next 3 SB.
+func slackCommandHandler(w http.ResponseWriter, r *http.Request) {
+	c := gae.NewContext(r)
+	form, ok := readSlackForm(c, w, r)
+	if !ok {
+		return
+	}
+	cmd := slack.ParseSlashCommand(form)
+	log.Infof(c, "RECV slack: %+v", cmd)
+
+	if err := slackCommandLater.Call(c, cmd); err != nil {
+		log.Errorf(c, "Can't queue slack command: %v", err)
+		http.Error(w, "can't queue command", http.StatusInternalServerError)
+	}
+}
+
+func slackCommandReply(c ctx.Context, cmd slack.SlashCommand) {
+	req := Request{UserId: slackUserId(cmd.TeamId, cmd.UserId), Text: cmd.Text}
+	msg := slackMessage(slackRespond(c, cmd.Command, cmd.TeamId, req))
+	if err := slack.Respond(urlfetch.Client(c), cmd.ResponseURL, msg); err != nil {
+		log.Errorf(c, "Send error: %v", err)
+	}
+}
+
+// Button presses from earlier replies, answered with a new message rather than
+// replacing the one they were on.
+func slackActionsHandler(w http.ResponseWriter, r *http.Request) {
+	c := gae.NewContext(r)
+	form, ok := readSlackForm(c, w, r)
+	if !ok {
+		return
+	}
+	interaction, err := slack.ParseInteraction(form)
+	if err != nil {
+		log.Errorf(c, "Handler error: %+v", err)
+		http.Error(w, "can't parse payload", http.StatusBadRequest)
+		return
+	}
+	log.Infof(c, "RECV slack action: %+v", interaction)
+
+	for _, action := range interaction.Actions {
+		if action.Value == "" {
+			// Link buttons report being pressed too, but the browser's dealt with them.
+			continue
+		}
+		if err := slackActionLater.Call(c, interaction.Team.Id, interaction.User.Id, action.Value, interaction.ResponseURL); err != nil {
+			log.Errorf(c, "Can't queue slack action: %v", err)
+			http.Error(w, "can't queue action", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func slackActionReply(c ctx.Context, teamId string, userId string, postback string, responseURL string) {
+	req := Request{UserId: slackUserId(teamId, userId), Postback: postback}
+	msg := slackMessage(slackRespond(c, "", teamId, req))
+	if err := slack.Respond(urlfetch.Client(c), responseURL, msg); err != nil {
+		log.Errorf(c, "Send error: %v", err)
+	}
+}
+
+// readSlackForm reads a request's form once its signature is checked, as it has to
+// be checked against the body exactly as sent.
+func readSlackForm(c ctx.Context, w http.ResponseWriter, r *http.Request) (url.Values, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MAX_WEBHOOK_BYTES))
+	if err != nil {
+		log.Errorf(c, "Can't read slack body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return nil, false
+	}
+	err = slack.CheckSignature(SLACK_SIGNING_SECRET, r.Header, body, time.Now())
+	if !checkedSignature(c, w, "slack", err, slack.ErrNoSecret, slack.ErrUnsigned) {
+		return nil, false
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		log.Errorf(c, "Handler error: %+v", err)
+		http.Error(w, "can't parse form", http.StatusBadRequest)
+		return nil, false
+	}
+	return form, true
+}
+
+// User IDs are only unique within a workspace, and prefixed so they can't clash with other platforms'.
+func slackUserId(teamId string, userId string) string {
+	return "slack:" + teamId + ":" + userId
+}
+
+// slackRespond answers from the team's office station, there being no locations in Slack,
+// and handles saving it with 'office [station]'.
+func slackRespond(c ctx.Context, command string, teamId string, req Request) []Reply {
+	if DATA == nil {
+		return respond(c, req)
+	}
+	if command == "" {
+		command = "/caltrain"
+	}
+	lowerText := strings.ToLower(strings.TrimSpace(req.Text))
+	if lowerText == "office" || strings.HasPrefix(lowerText, "office ") {
+		return []Reply{slackOfficeAction(c, command, teamId, strings.TrimSpace(lowerText[len("office"):]))}
+	}
+
+	office := slackOffice(c, teamId)
+	if office != nil {
+		SetUserState(c, req.UserId, UserState{
+			fb.Coordinates{office.Lat, office.Long},
+			*office,
+		})
+	} else if lowerText == "help" || lowerText == "commands" {
+		return []Reply{textReply(slackOfficeUsage(command, nil) + slackHelpText(command))}
+	} else if req.Postback == "" && lowerText != "alerts" && !isWhereIsRequest(lowerText) &&
+		!strings.HasPrefix(lowerText, "from ") {
+		// Everything else needs to know where the rider is.
+		return []Reply{textReply(slackOfficeUsage(command, nil))}
+	}
+	return respond(c, req)
+}
+
+// What can be asked before the team has an office station.
+func slackHelpText(command string) string {
+	text := fmt.Sprintf("Until then, plan a trip with e.g. %s from Palo Alto to Millbrae\n", command)
+	text += fmt.Sprintf("Or ask after a train with e.g. %s where is train 123, or hear about delays with %s alerts\n", command, command)
+	return text
+}
+
+// Show or change the station next trains are from, for everyone in the team.
+func slackOfficeAction(c ctx.Context, command string, teamId string, name string) Reply {
+	if name == "" {
+		return textReply(slackOfficeUsage(command, slackOffice(c, teamId)))
+	}
+	station, found := DATA.FindStation(name)
+	if !found {
+		return unknownStationMessage(name)
+	}
+	key := datastore.NewKey(c, "SlackTeam", teamId, 0, nil)
+	if _, err := datastore.Put(c, key, &slackTeam{station.StopId}); err != nil {
+		log.Errorf(c, "error saving office for team %s: %v", teamId, err)
+		return textReply("Sorry, I couldn't save that ☹ - please try again later.")
+	}
+	return textReply(fmt.Sprintf("Got it, trains are from %s now. Try: %s next 3 SB\n", shortStopName(station.Name), command))
+}
+
+func slackOfficeUsage(command string, office *Stop) string {
+	if office == nil {
+		return fmt.Sprintf("I don't know your team's office station yet - set it with e.g. %s office Palo Alto\n", command)
+	}
+	return fmt.Sprintf("Your team's office station is %s - change it with e.g. %s office Palo Alto\n",
+		shortStopName(office.Name), command)
+}
+
+// slackOffice is the station a team saved, or nil if it hasn't.
+func slackOffice(c ctx.Context, teamId string) *Stop {
+	var team slackTeam
+	key := datastore.NewKey(c, "SlackTeam", teamId, 0, nil)
+	if err := datastore.Get(c, key, &team); err == datastore.ErrNoSuchEntity {
+		return nil
+	} else if err != nil {
+		log.Errorf(c, "error getting office for team %s: %v", teamId, err)
+		return nil
+	}
+	return DATA.GetStop(team.OfficeStopId)
+}
+
+// slackMessage renders replies as Block Kit sections, each followed by its buttons.
+func slackMessage(replies []Reply) slack.Message {
+	msg := slack.Message{}
+	texts := []string{}
+	for _, reply := range replies {
+		texts = append(texts, reply.Text)
+		msg.Blocks = append(msg.Blocks, slack.SectionBlock(reply.Text))
+
+		// Action IDs have to be unique within the message.
+		buttons := []slack.Element{}
+		actionId := func() string {
+			return fmt.Sprintf("%d-%d", len(msg.Blocks), len(buttons))
+		}
+		for _, choice := range reply.Choices {
+			buttons = append(buttons, slack.Button(choice.Caption, actionId(), choice.Payload))
+		}
+		for _, link := range reply.Links {
+			buttons = append(buttons, slack.LinkButton(link.Caption, actionId(), link.URL))
+		}
+		if reply.Location != nil {
+			buttons = append(buttons, slack.LinkButton("Map", actionId(), mapsPlace(&reply.Location.At)))
+		}
+		if len(buttons) > 0 {
+			msg.Blocks = append(msg.Blocks, slack.ActionsBlock(buttons))
+		}
+	}
+	msg.Text = strings.Join(texts, "\n")
+	return msg
+}
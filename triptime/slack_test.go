@@ -0,0 +1,103 @@
+package triptime
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/padster/triptime/slack"
+
+	gae "google.golang.org/appengine"
+	"google.golang.org/appengine/aetest"
+)
+
+// fakeResponseURL records the messages posted to it.
+type fakeResponseURL struct {
+	lock     sync.Mutex
+	messages []slack.Message
+}
+
+func (f *fakeResponseURL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var msg slack.Message
+	body, _ := ioutil.ReadAll(r.Body)
+	json.Unmarshal(body, &msg)
+	f.lock.Lock()
+	f.messages = append(f.messages, msg)
+	f.lock.Unlock()
+}
+
+// text is what was posted since the last call.
+func (f *fakeResponseURL) text() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	texts := []string{}
+	for _, msg := range f.messages {
+		texts = append(texts, msg.Text)
+	}
+	f.messages = nil
+	return strings.Join(texts, "\n")
+}
+
+// Needs the App Engine SDK's dev_appserver.py, for datastore, memcache, task queues and urlfetch.
+func TestSlackCommand(t *testing.T) {
+	inst, err := aetest.NewInstance(&aetest.Options{SuppressDevAppServerLog: true, StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Skipf("can't start dev_appserver: %v", err)
+	}
+	defer inst.Close()
+
+	fake := &fakeResponseURL{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	saved := DATA
+	DATA = loadFeed(t, TEST_FEED, nil)
+	CLOCK = FixedClock(at(t, DATA, "2026-10-21 06:50"))
+	defer func() { DATA, CLOCK = saved, systemClock{} }()
+
+	// The command is acknowledged straight away, with the answer to come.
+	body := "team_id=T1&user_id=U1&command=%2Fcaltrain&text=help&response_url=" + server.URL
+	r, err := inst.NewRequest("POST", "/_/slack/command", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := fmt.Sprint(time.Now().Unix())
+	mac := hmac.New(sha256.New, []byte(SLACK_SIGNING_SECRET))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	slackCommandHandler(w, r)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("command: got status %d with %q, want an empty %d", w.Code, w.Body, http.StatusOK)
+	}
+
+	command := func(text string) string {
+		r, err := inst.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slackCommandReply(gae.NewContext(r), slack.SlashCommand{"T1", "C1", "U1", "/caltrain", text, server.URL})
+		return fake.text()
+	}
+
+	// Help works before there's an office to say what's next from.
+	if got := command("help"); !strings.Contains(got, "/caltrain office Palo Alto") || !strings.Contains(got, "/caltrain alerts") {
+		t.Errorf("help without an office: got %q", got)
+	}
+	if got := command("office palo alto"); !strings.Contains(got, "trains are from Palo Alto") {
+		t.Errorf("office: got %q", got)
+	}
+	if got := command("help"); !strings.Contains(got, "You're near Palo Alto") {
+		t.Errorf("help from the office: got %q", got)
+	}
+}
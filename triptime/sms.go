@@ -0,0 +1,224 @@
+package triptime
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/padster/triptime/twilio"
+
+	ctx "golang.org/x/net/context"
+	gae "google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/memcache"
+)
+
+const (
+	// Longest message Twilio sends, split over several concatenated SMS.
+	SMS_MAX_CHARS = 1600
+	// Payload of the choice that sends the rest of a reply too long for one message.
+	SMS_MORE = "#more"
+)
+
+func init() {
+	http.HandleFunc("/_/sms", smsHandler)
+}
+
+// Twilio SMS webhook, answered with TwiML in the response.
+func smsHandler(w http.ResponseWriter, r *http.Request) {
+	c := gae.NewContext(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_WEBHOOK_BYTES)
+	if err := r.ParseForm(); err != nil {
+		log.Errorf(c, "Handler error: %+v", err)
+		http.Error(w, "can't parse form", http.StatusBadRequest)
+		return
+	}
+	err := twilio.CheckSignature(TWILIO_AUTH_TOKEN, r.Header, smsWebhookURL(r), r.PostForm)
+	if !checkedSignature(c, w, "sms webhook", err, twilio.ErrNoSecret, twilio.ErrUnsigned) {
+		return
+	}
+
+	msg := twilio.ParseMessage(r.PostForm)
+	log.Infof(c, "RECV sms: %+v", msg)
+	req := smsRequest(c, msg)
+	var text string
+	if req.Postback == SMS_MORE {
+		text = smsMoreText(c, req.UserId)
+	} else {
+		text = smsText(c, req.UserId, smsRespond(c, req))
+	}
+	response := twilio.Response{Messages: []string{text}}
+	body, err := response.Marshal()
+	if err != nil {
+		log.Errorf(c, "Invalid outbound message: %+v", response)
+		http.Error(w, "can't marshal TwiML", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(body)
+}
+
+// smsWebhookURL is the URL Twilio requested, which it signs. Set TWILIO_WEBHOOK_URL
+// if that's not what the app sees, e.g. behind a proxy.
+func smsWebhookURL(r *http.Request) string {
+	if webhookURL := os.Getenv("TWILIO_WEBHOOK_URL"); webhookURL != "" {
+		return webhookURL
+	}
+	scheme := "https"
+	if r.TLS == nil && gae.IsDevAppServer() {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// smsRequest is the rider's text, or the choice they picked if it's the number of one.
+func smsRequest(c ctx.Context, msg twilio.Message) Request {
+	req := Request{UserId: "sms:" + msg.From, Text: msg.Body}
+	if n, err := strconv.Atoi(strings.TrimSpace(msg.Body)); err == nil {
+		choices := getSmsChoices(c, req.UserId)
+		if n >= 1 && n <= len(choices) {
+			req.Text, req.Postback = "", choices[n-1].Payload
+		}
+	}
+	return req
+}
+
+// smsRespond asks for a station rather than a location, which phones without data can't send.
+func smsRespond(c ctx.Context, req Request) []Reply {
+	lowerText := strings.ToLower(strings.TrimSpace(req.Text))
+	if req.Postback == "" && (lowerText == "" || lowerText == "help") && GetUserState(c, req.UserId) == nil {
+		return []Reply{textReply("Welcome to Triptime! Text the station you're at, e.g. Palo Alto, for its next trains.")}
+	}
+	return respond(c, req)
+}
+
+// smsText joins replies into one compact text, with their choices numbered to text
+// back in place of buttons. Links are left out, being long and of little use without
+// a smartphone. Past SMS_MAX_CHARS, the rest is kept for a numbered "More" choice.
+func smsText(c ctx.Context, userId string, replies []Reply) string {
+	lines := []string{}
+	choices := []Choice{}
+	for _, reply := range replies {
+		lines = append(lines, compactText(reply.Text))
+		choices = append(choices, reply.Choices...)
+	}
+	if len(choices) > 0 {
+		lines = append(lines, "Reply with:")
+		for i, choice := range choices {
+			lines = append(lines, fmt.Sprintf("%d %s", i+1, choice.Caption))
+		}
+	}
+	text, rest := smsPage(strings.Join(lines, "\n"), len(choices)+1)
+	if rest != "" {
+		choices = append(choices, Choice{"More", SMS_MORE})
+	}
+	setSmsChoices(c, userId, choices)
+	setSmsMore(c, userId, rest)
+	return text
+}
+
+// smsMoreText is the next part of a long reply, keeping the choices it listed.
+func smsMoreText(c ctx.Context, userId string) string {
+	choices := getSmsChoices(c, userId)
+	text, rest := smsPage(getSmsMore(c, userId), len(choices))
+	if text == "" {
+		return "That's all, sorry - please ask again for more."
+	}
+	setSmsMore(c, userId, rest)
+	return text
+}
+
+// smsPage splits text at the last line break that fits in one message along with the
+// "More" choice numbered moreNumber, or mid-line if a line is too long by itself.
+func smsPage(text string, moreNumber int) (string, string) {
+	if utf8.RuneCountInString(text) <= SMS_MAX_CHARS {
+		return text, ""
+	}
+	more := fmt.Sprintf("\nReply with:\n%d More", moreNumber)
+	runes := []rune(text)
+	n := SMS_MAX_CHARS - utf8.RuneCountInString(more)
+	if cut := strings.LastIndex(string(runes[:n]), "\n"); cut > 0 {
+		return text[:cut] + more, text[cut+1:]
+	}
+	return string(runes[:n]) + more, string(runes[n:])
+}
+
+// compactText drops emoji and extra spacing, and spells out arrows and the ellipsis
+// truncate adds, any of which would otherwise send the text as UCS-2 and fit less than
+// half as much in each SMS.
+func compactText(text string) string {
+	text = strings.Replace(text, "→", "-", -1)
+	text = strings.Replace(text, "…", "...", -1)
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.Map(func(r rune) rune {
+			if unicode.Is(unicode.So, r) || r == '\ufe0f' {
+				return -1
+			}
+			return r
+		}, line)
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// The numbered choices last sent to a rider, kept as long as their user state.
+func getSmsChoices(c ctx.Context, userID string) []Choice {
+	var choices []Choice
+	if _, err := memcache.JSON.Get(c, "smschoices/"+userID, &choices); err != nil && err != memcache.ErrCacheMiss {
+		log.Errorf(c, "error getting sms choices for user %s: %v", userID, err)
+	}
+	return choices
+}
+
+func setSmsChoices(c ctx.Context, userID string, choices []Choice) {
+	err := memcache.JSON.Set(c, &memcache.Item{
+		Key:        "smschoices/" + userID,
+		Object:     choices,
+		Expiration: 600 * time.Second,
+	})
+	if err != nil {
+		log.Errorf(c, "error writing sms choices for user %s: %v", userID, err)
+	}
+}
+
+// The rest of a reply too long for one message, kept as long as the choices.
+func getSmsMore(c ctx.Context, userID string) string {
+	item, err := memcache.Get(c, "smsmore/"+userID)
+	if err != nil {
+		if err != memcache.ErrCacheMiss {
+			log.Errorf(c, "error getting sms text for user %s: %v", userID, err)
+		}
+		return ""
+	}
+	return string(item.Value)
+}
+
+func setSmsMore(c ctx.Context, userID string, text string) {
+	var err error
+	if text == "" {
+		if err = memcache.Delete(c, "smsmore/"+userID); err == memcache.ErrCacheMiss {
+			err = nil
+		}
+	} else {
+		err = memcache.Set(c, &memcache.Item{
+			Key:        "smsmore/" + userID,
+			Value:      []byte(text),
+			Expiration: 600 * time.Second,
+		})
+	}
+	if err != nil {
+		log.Errorf(c, "error writing sms text for user %s: %v", userID, err)
+	}
+}
@@ -0,0 +1,44 @@
+package triptime
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSmsPage(t *testing.T) {
+	line := strings.Repeat("x", 99)
+	long := strings.TrimSuffix(strings.Repeat(line+"\n", 20), "\n") // 1999 chars.
+	tests := []struct {
+		name     string
+		text     string
+		wantText string
+		wantRest string
+	}{
+		{"fits", "short", "short", ""},
+		{"exactly fits", strings.Repeat("x", SMS_MAX_CHARS), strings.Repeat("x", SMS_MAX_CHARS), ""},
+		{"split at a line", long,
+			strings.Repeat(line+"\n", 14) + line + "\nReply with:\n4 More",
+			strings.TrimSuffix(strings.Repeat(line+"\n", 5), "\n")},
+		{"split mid-line", strings.Repeat("é", 2000),
+			strings.Repeat("é", SMS_MAX_CHARS-19) + "\nReply with:\n4 More",
+			strings.Repeat("é", 2000-SMS_MAX_CHARS+19)},
+	}
+	for _, test := range tests {
+		text, rest := smsPage(test.text, 4)
+		if text != test.wantText || rest != test.wantRest {
+			t.Errorf("%s: got %q, %q, want %q, %q", test.name, text, rest, test.wantText, test.wantRest)
+		}
+		if n := utf8.RuneCountInString(text); n > SMS_MAX_CHARS {
+			t.Errorf("%s: got %d chars, want at most %d", test.name, n, SMS_MAX_CHARS)
+		}
+	}
+}
+
+func TestCompactText(t *testing.T) {
+	got := compactText("🚆  Palo Alto → SF\n\n⚠️ Delays near Millbrae after an inci…")
+	want := "Palo Alto - SF\nDelays near Millbrae after an inci..."
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+package triptime
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/padster/triptime/fb"
+	"github.com/padster/triptime/telegram"
+
+	ctx "golang.org/x/net/context"
+	gae "google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/memcache"
+	"google.golang.org/appengine/urlfetch"
+)
+
+const (
+	// How long buttons with payloads too long for Telegram keep working.
+	TELEGRAM_CALLBACK_EXPIRY = 24 * time.Hour
+	// Postback for a button whose payload is no longer in memcache.
+	TELEGRAM_EXPIRED = "#expired"
+)
+
+func init() {
+	http.HandleFunc("/_/telegram", telegramHandler)
+}
+
+// Telegram webhook: updates with messages, locations and button presses, answered through the Bot API.
+func telegramHandler(w http.ResponseWriter, r *http.Request) {
+	c := gae.NewContext(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := telegram.CheckSecretToken(TELEGRAM_SECRET_TOKEN, r.Header); err != nil {
+		log.Warningf(c, "Rejecting telegram update: %v", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var update telegram.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_WEBHOOK_BYTES)).Decode(&update); err != nil {
+		log.Errorf(c, "Handler error: %+v", err)
+		http.Error(w, "can't parse body", http.StatusBadRequest)
+		return
+	}
+	log.Infof(c, "RECV tg: %+v", update)
+
+	req, chatId, ok := telegramRequest(c, update)
+	if !ok {
+		log.Infof(c, "Ignoring telegram update %d", update.UpdateId)
+		return
+	}
+	bot, ok := telegramBot(c)
+	if !ok {
+		log.Infof(c, "Not replying, no TELEGRAM_API_URL set on dev app server")
+		return
+	}
+	if update.CallbackQuery != nil {
+		if err := bot.AnswerCallbackQuery(update.CallbackQuery.Id); err != nil {
+			log.Warningf(c, "Can't answer callback: %v", err)
+		}
+	}
+	replies := []Reply{textReply("Sorry, that button has expired - please ask again.")}
+	if req.Postback != TELEGRAM_EXPIRED {
+		replies = respond(c, req)
+	}
+	// Telegram retries updates that fail, so errors past here are only logged.
+	for _, reply := range replies {
+		if err := bot.SendMessage(telegramMessage(c, chatId, reply)); err != nil {
+			log.Errorf(c, "Send error: %v", err)
+			return
+		}
+	}
+}
+
+// telegramRequest is the rider's message or button press, and the chat to answer in.
+// User IDs are prefixed so they can't clash with Messenger ones in the user state.
+func telegramRequest(c ctx.Context, update telegram.Update) (Request, int64, bool) {
+	if query := update.CallbackQuery; query != nil {
+		if query.Message == nil {
+			return Request{}, 0, false
+		}
+		return Request{
+			UserId:   telegramUserId(query.From.Id),
+			Postback: telegramPayload(c, query.Data),
+		}, query.Message.Chat.Id, true
+	}
+
+	msg := update.Message
+	if msg == nil {
+		return Request{}, 0, false
+	}
+	userId := msg.Chat.Id
+	if msg.From != nil {
+		userId = msg.From.Id
+	}
+	req := Request{UserId: telegramUserId(userId)}
+	if msg.Location != nil {
+		req.Location = &fb.Coordinates{msg.Location.Latitude, msg.Location.Longitude}
+		return req, msg.Chat.Id, true
+	}
+	req.Text = msg.CommandText()
+	if strings.EqualFold(strings.TrimSpace(req.Text), "start") {
+		// Sent when someone first opens the bot.
+		req.Text = "help"
+	}
+	return req, msg.Chat.Id, req.Text != ""
+}
+
+func telegramUserId(id int64) string {
+	return "tg:" + strconv.FormatInt(id, 10)
+}
+
+// telegramBot sends to TELEGRAM_API_URL if set, e.g. a fake server when testing,
+// otherwise to the real Bot API - but not from the dev app server.
+func telegramBot(c ctx.Context) (telegram.Bot, bool) {
+	url := os.Getenv("TELEGRAM_API_URL")
+	if url == "" {
+		if gae.IsDevAppServer() {
+			return telegram.Bot{}, false
+		}
+		url = telegram.API_URL
+	}
+	return telegram.Bot{url, TELEGRAM_BOT_TOKEN, urlfetch.Client(c)}, true
+}
+
+// telegramMessage renders a reply with an inline keyboard, one button per row,
+// choices coming back as callback data.
+func telegramMessage(c ctx.Context, chatId int64, reply Reply) telegram.SendMessage {
+	keyboard := &telegram.InlineKeyboardMarkup{}
+	for _, choice := range reply.Choices {
+		data, ok := telegramCallbackData(c, choice.Payload)
+		if !ok {
+			continue
+		}
+		keyboard.AddRow(telegram.InlineKeyboardButton{Text: choice.Caption, CallbackData: data})
+	}
+	for _, link := range reply.Links {
+		keyboard.AddRow(telegram.InlineKeyboardButton{Text: link.Caption, URL: link.URL})
+	}
+	if reply.Location != nil {
+		keyboard.AddRow(telegram.InlineKeyboardButton{Text: "Map", URL: mapsPlace(&reply.Location.At)})
+	}
+
+	msg := telegram.SendMessage{
+		ChatId:                chatId,
+		Text:                  reply.Text,
+		DisableWebPagePreview: true,
+	}
+	if len(keyboard.InlineKeyboard) > 0 {
+		msg.ReplyMarkup = keyboard
+	}
+	return msg
+}
+
+// telegramCallbackData fits a choice's payload in the 64 bytes Telegram allows. Longer
+// ones, e.g. with several feeds' namespaced IDs, are kept in memcache under their hash.
+func telegramCallbackData(c ctx.Context, payload string) (string, bool) {
+	if len(payload) <= telegram.MAX_CALLBACK_DATA && !strings.HasPrefix(payload, "#") {
+		return payload, true
+	}
+	sum := sha1.Sum([]byte(payload))
+	data := "#" + base64.RawURLEncoding.EncodeToString(sum[:])
+	err := memcache.Set(c, &memcache.Item{
+		Key:        "tgcallback/" + data,
+		Value:      []byte(payload),
+		Expiration: TELEGRAM_CALLBACK_EXPIRY,
+	})
+	if err != nil {
+		log.Errorf(c, "Dropping choice, can't save its payload %s: %v", payload, err)
+		return "", false
+	}
+	return data, true
+}
+
+// telegramPayload is the payload of a pressed button, looked up if it was too long to send.
+func telegramPayload(c ctx.Context, data string) string {
+	if !strings.HasPrefix(data, "#") {
+		return data
+	}
+	item, err := memcache.Get(c, "tgcallback/"+data)
+	if err != nil {
+		if err != memcache.ErrCacheMiss {
+			log.Errorf(c, "error getting callback %s: %v", data, err)
+		}
+		return TELEGRAM_EXPIRED
+	}
+	return string(item.Value)
+}
@@ -0,0 +1,124 @@
+package triptime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/padster/triptime/telegram"
+
+	"google.golang.org/appengine/aetest"
+)
+
+// fakeBotAPI records the Bot API calls made to it, all of which succeed.
+type fakeBotAPI struct {
+	lock  sync.Mutex
+	calls map[string][]string // Request bodies by method.
+}
+
+func (f *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	f.lock.Lock()
+	f.calls[method] = append(f.calls[method], string(body))
+	f.lock.Unlock()
+	w.Write([]byte(`{"ok":true,"result":{}}`))
+}
+
+// sent is what was sent with sendMessage since the last call.
+func (f *fakeBotAPI) sent(t *testing.T) []telegram.SendMessage {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	messages := []telegram.SendMessage{}
+	for _, body := range f.calls["sendMessage"] {
+		var msg telegram.SendMessage
+		if err := json.Unmarshal([]byte(body), &msg); err != nil {
+			t.Fatalf("bad sendMessage %s: %v", body, err)
+		}
+		messages = append(messages, msg)
+	}
+	f.calls = map[string][]string{}
+	return messages
+}
+
+// Needs the App Engine SDK's dev_appserver.py, for memcache and urlfetch.
+func TestTelegramHandler(t *testing.T) {
+	inst, err := aetest.NewInstance(&aetest.Options{SuppressDevAppServerLog: true})
+	if err != nil {
+		t.Skipf("can't start dev_appserver: %v", err)
+	}
+	defer inst.Close()
+
+	fake := &fakeBotAPI{calls: map[string][]string{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	os.Setenv("TELEGRAM_API_URL", server.URL)
+	defer os.Unsetenv("TELEGRAM_API_URL")
+
+	// Trip IDs as long as Caltrain's, namespaced as with several feeds, don't fit in callback data.
+	longId := "CT-26OCT-Combo-Weekday-01-t1"
+	d := loadFeed(t, TEST_FEED, memFeed{
+		"trips.txt":      strings.Replace(TEST_FEED["trips.txt"], ",t1,", ","+longId+",", 1),
+		"stop_times.txt": strings.Replace(TEST_FEED["stop_times.txt"], "\nt1,", "\n"+longId+",", -1),
+	})
+	d.namespace("caltrain")
+	d.buildIndex()
+	saved := DATA
+	DATA = d
+	CLOCK = FixedClock(at(t, d, "2026-10-21 06:50"))
+	defer func() { DATA, CLOCK = saved, systemClock{} }()
+
+	post := func(update string, secretToken string) int {
+		r, err := inst.NewRequest("POST", "/_/telegram", strings.NewReader(update))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("X-Telegram-Bot-Api-Secret-Token", secretToken)
+		w := httptest.NewRecorder()
+		telegramHandler(w, r)
+		return w.Code
+	}
+
+	if code := post(`{"update_id":1}`, "guess"); code != http.StatusForbidden {
+		t.Errorf("wrong secret token: got status %d, want %d", code, http.StatusForbidden)
+	}
+
+	// Sharing a location gets the next trains, with a button to list each one's stops.
+	location := `{"update_id":2,"message":{"message_id":5,"from":{"id":42},"chat":{"id":99,"type":"private"},
+		"location":{"latitude":37.4431,"longitude":-122.1649}}}`
+	if code := post(location, TELEGRAM_SECRET_TOKEN); code != http.StatusOK {
+		t.Fatalf("location: got status %d", code)
+	}
+	sent := fake.sent(t)
+	var button *telegram.InlineKeyboardButton
+	for _, msg := range sent {
+		if msg.ChatId != 99 {
+			t.Errorf("location: sent to chat %d, want 99", msg.ChatId)
+		}
+		if msg.ReplyMarkup != nil && len(msg.ReplyMarkup.InlineKeyboard) > 0 {
+			button = &msg.ReplyMarkup.InlineKeyboard[0][0]
+		}
+	}
+	if button == nil {
+		t.Fatalf("location: sent %+v, want a button per train", sent)
+	}
+	if len(button.CallbackData) == 0 || len(button.CallbackData) > telegram.MAX_CALLBACK_DATA {
+		t.Fatalf("button %q has callback data %q, want 1-%d bytes", button.Text, button.CallbackData, telegram.MAX_CALLBACK_DATA)
+	}
+
+	// Pressing it lists the stops.
+	press := `{"update_id":3,"callback_query":{"id":"cb1","from":{"id":42},
+		"message":{"message_id":6,"chat":{"id":99,"type":"private"}},"data":"` + button.CallbackData + `"}}`
+	if code := post(press, TELEGRAM_SECRET_TOKEN); code != http.StatusOK {
+		t.Fatalf("button press: got status %d", code)
+	}
+	sent = fake.sent(t)
+	if len(sent) == 0 || !strings.Contains(sent[0].Text, "07:30 - San Francisco") {
+		t.Errorf("button press: sent %+v, want the trip's stops", sent)
+	}
+}
@@ -13,7 +13,7 @@ import (
 )
 
 // Given text, use google's API to convert it to lat/long
-func maybeTextToPosition(c ctx.Context, msg fb.Message, text string) *fb.Coordinates {
+func maybeTextToPosition(c ctx.Context, req Request, text string) *fb.Coordinates {
   log.Infof(c, "Converting \"%s\" to position", text)
 
   log.Infof(c, "Opening client...")
@@ -0,0 +1,208 @@
+package triptime
+
+import (
+	"strings"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"github.com/padster/triptime/fb"
+)
+
+const (
+	// Vehicle reports older than this are ignored in favour of the timetable.
+	VEHICLE_MAX_AGE = 5 * time.Minute
+)
+
+// A train's report of where it is, from the realtime VehiclePositions.
+type vehiclePosition struct {
+	position fb.Coordinates
+	stopSeq  int // Stop it's at or heading to, 0 if it didn't say.
+	stopped  bool
+	at       time.Time
+}
+
+// TrainLocation is where a train is on its trip, and where it calls next.
+type TrainLocation struct {
+	Trip         *Trip
+	ServiceDate  time.Time
+	Position     fb.Coordinates
+	Live         bool // Reported by the train, rather than worked out from the timetable.
+	Stopped      bool // At NextStop rather than on the way there.
+	LastStop     *Stop
+	NextStop     Stop
+	NextStopTime *StopTime
+	Prediction   Prediction // At NextStop.
+}
+
+// NextArrivalText is the scheduled arrival at NextStop, with the predicted one and delay if known.
+func (l TrainLocation) NextArrivalText() string {
+	return l.Prediction.Describe(l.NextStopTime.Arrival, l.Prediction.ArrivalDelay)
+}
+
+func (rt *Realtime) addVehicle(d *GTFSData, vehicle *gtfs.VehiclePosition, feedName string) {
+	descriptor := vehicle.GetTrip()
+	trip := d.GetTrip(feedId(feedName, descriptor.GetTripId()))
+	if trip == nil || vehicle.GetPosition() == nil {
+		return
+	}
+	serviceDate, ok := d.realtimeServiceDate(trip, descriptor.GetStartDate(), rt.Fetched)
+	if !ok {
+		return
+	}
+
+	vp := vehiclePosition{
+		position: fb.Coordinates{
+			float64(vehicle.GetPosition().GetLatitude()),
+			float64(vehicle.GetPosition().GetLongitude()),
+		},
+		stopped: vehicle.GetCurrentStatus() == gtfs.VehiclePosition_STOPPED_AT,
+		at:      rt.Fetched,
+	}
+	if vehicle.GetTimestamp() != 0 {
+		vp.at = time.Unix(int64(vehicle.GetTimestamp()), 0)
+	}
+	stopId := feedId(feedName, vehicle.GetStopId())
+	for _, stopTime := range d.SortedStopTimesForTrip(trip.TripId) {
+		if vehicle.CurrentStopSequence != nil && stopTime.StopSeq == int(vehicle.GetCurrentStopSequence()) ||
+			vehicle.CurrentStopSequence == nil && stopId != "" && stopTime.StopId == stopId {
+			vp.stopSeq = stopTime.StopSeq
+			break
+		}
+	}
+	rt.vehicles[trip.TripId+"@"+dateAsString(serviceDate)] = vp
+}
+
+// A stop the train will call at, at the times the realtime feed predicts.
+type call struct {
+	stop       *Stop
+	stopTime   *StopTime
+	prediction Prediction
+	arrives    time.Time
+	departs    time.Time
+}
+
+// LocateTrain works out where a train is at t, from its own report when there is a
+// recent one, otherwise between the stops it's left and is due at next. It's false
+// if the train hasn't set off yet or has already finished.
+func (d *GTFSData) LocateTrain(trip *Trip, serviceDate time.Time, t time.Time) (TrainLocation, bool) {
+	rt := d.Realtime()
+	stopTimes := d.SortedStopTimesForTrip(trip.TripId)
+	calls := []call{}
+	for i := range stopTimes {
+		stop := d.GetStop(stopTimes[i].StopId)
+		p := rt.Predict(trip, serviceDate, &stopTimes[i])
+		if stop == nil || p.Cancelled {
+			continue
+		}
+		calls = append(calls, call{
+			stop,
+			&stopTimes[i],
+			p,
+			stopTimes[i].Arrival.On(serviceDate).Add(p.ArrivalDelay),
+			stopTimes[i].Departure.On(serviceDate).Add(p.DepartureDelay),
+		})
+	}
+	if len(calls) == 0 {
+		return TrainLocation{}, false
+	}
+
+	live, hasLive := rt.vehicle(trip, serviceDate, t)
+	running := !t.Before(calls[0].arrives) && t.Before(calls[len(calls)-1].departs)
+	if !running && !hasLive {
+		return TrainLocation{}, false
+	}
+
+	// Which call is next by the timetable, and how far along the way there the train is.
+	next, fraction := len(calls)-1, 1.0
+	for i, c := range calls {
+		if t.Before(c.arrives) {
+			next, fraction = i, 0
+			if i > 0 {
+				leg := c.arrives.Sub(calls[i-1].departs)
+				if leg > 0 {
+					fraction = float64(t.Sub(calls[i-1].departs)) / float64(leg)
+				}
+			}
+			break
+		}
+		if t.Before(c.departs) {
+			next, fraction = i, 1
+			break
+		}
+	}
+	stopped := fraction >= 1
+
+	position := fb.Coordinates{calls[next].stop.Lat, calls[next].stop.Long}
+	if next > 0 && !stopped {
+		from := calls[next-1].stop
+		position = fb.Coordinates{
+			from.Lat + (position.Lat-from.Lat)*fraction,
+			from.Long + (position.Long-from.Long)*fraction,
+		}
+	}
+	if hasLive {
+		position, stopped = live.position, live.stopped
+		for i, c := range calls {
+			if live.stopSeq != 0 && c.stopTime.StopSeq >= live.stopSeq {
+				next = i
+				break
+			}
+		}
+	}
+
+	loc := TrainLocation{
+		trip,
+		serviceDate,
+		position,
+		hasLive,
+		stopped,
+		nil,
+		*calls[next].stop,
+		calls[next].stopTime,
+		calls[next].prediction,
+	}
+	if next > 0 {
+		loc.LastStop = calls[next-1].stop
+	}
+	return loc, true
+}
+
+// vehicle is the train's own report of where it is, if it's made a recent one.
+func (rt *Realtime) vehicle(trip *Trip, serviceDate time.Time, t time.Time) (vehiclePosition, bool) {
+	if rt == nil {
+		return vehiclePosition{}, false
+	}
+	vp, found := rt.vehicles[trip.TripId+"@"+dateAsString(serviceDate)]
+	if !found || t.Sub(vp.at) > VEHICLE_MAX_AGE {
+		return vehiclePosition{}, false
+	}
+	return vp, true
+}
+
+// FindTrain looks up a train by its number (trip_short_name) or trip ID, preferring
+// a run that's out now, then the next to set off. Case doesn't matter, as it's typed
+// in. It's false if neither is found.
+func (d *GTFSData) FindTrain(name string, t time.Time) (*Trip, time.Time, bool) {
+	var next *Trip
+	var nextDate, nextDeparts time.Time
+	for _, day := range d.ServiceDaysAround(t) {
+		for _, trip := range day.Trips {
+			if !strings.EqualFold(trip.ShortName, name) && !strings.EqualFold(trip.TripId, name) &&
+				!strings.HasSuffix(strings.ToLower(trip.TripId), ":"+strings.ToLower(name)) {
+				continue
+			}
+			if _, running := d.LocateTrain(trip, day.Date, t); running {
+				return trip, day.Date, true
+			}
+			stopTimes := d.SortedStopTimesForTrip(trip.TripId)
+			if len(stopTimes) == 0 {
+				continue
+			}
+			departs := stopTimes[0].Departure.On(day.Date)
+			if departs.After(t) && (next == nil || departs.Before(nextDeparts)) {
+				next, nextDate, nextDeparts = trip, day.Date, departs
+			}
+		}
+	}
+	return next, nextDate, next != nil
+}
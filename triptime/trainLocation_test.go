@@ -0,0 +1,69 @@
+package triptime
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLocateTrain(t *testing.T) {
+	d, err := LoadGTFS(memFeed{
+		"agency.txt":     "agency_id,agency_name,agency_url,agency_timezone\nCT,Caltrain,http://caltrain.com,America/Los_Angeles\n",
+		"calendar.txt":   "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nD,1,1,1,1,1,1,1,20260101,20261231\n",
+		"routes.txt":     "route_id,route_type\nL1,2\n",
+		"stops.txt":      "stop_id,stop_name,stop_lat,stop_lon\nsj,San Jose,37.30,-121.90\npa,Palo Alto,37.40,-122.10\nsf,San Francisco,37.80,-122.40\n",
+		"trips.txt":      "route_id,service_id,trip_id,trip_short_name\nL1,D,Bullet-101,101\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nBullet-101,07:00:00,07:00:00,sj,1\nBullet-101,07:20:00,07:22:00,pa,2\nBullet-101,08:00:00,08:00:00,sf,3\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trip := d.GetTrip("Bullet-101")
+	date := dateOf(at(t, d, "2026-10-21 12:00"))
+
+	tests := []struct {
+		name     string
+		now      string
+		running  bool
+		stopped  bool
+		lastStop string
+		nextStop string
+		lat      float64
+		long     float64
+	}{
+		{"not set off", "2026-10-21 06:50", false, false, "", "", 0, 0},
+		{"between stops", "2026-10-21 07:10", true, false, "sj", "pa", 37.35, -122.00},
+		{"at a stop", "2026-10-21 07:21", true, true, "sj", "pa", 37.40, -122.10},
+		{"finished", "2026-10-21 08:05", false, false, "", "", 0, 0},
+	}
+	for _, test := range tests {
+		loc, running := d.LocateTrain(trip, date, at(t, d, test.now))
+		if running != test.running {
+			t.Errorf("%s: got running %v, want %v", test.name, running, test.running)
+			continue
+		}
+		if !running {
+			continue
+		}
+		lastStop := ""
+		if loc.LastStop != nil {
+			lastStop = loc.LastStop.StopId
+		}
+		if loc.Stopped != test.stopped || lastStop != test.lastStop || loc.NextStop.StopId != test.nextStop {
+			t.Errorf("%s: got stopped %v from %q to %q, want stopped %v from %q to %q",
+				test.name, loc.Stopped, lastStop, loc.NextStop.StopId, test.stopped, test.lastStop, test.nextStop)
+		}
+		if math.Abs(loc.Position.Lat-test.lat) > 1e-6 || math.Abs(loc.Position.Long-test.long) > 1e-6 {
+			t.Errorf("%s: got position %v, want %v,%v", test.name, loc.Position, test.lat, test.long)
+		}
+	}
+
+	for _, name := range []string{"101", "Bullet-101", "bullet-101"} {
+		found, serviceDate, ok := d.FindTrain(name, at(t, d, "2026-10-21 07:10"))
+		if !ok || found != trip || !serviceDate.Equal(date) {
+			t.Errorf("FindTrain(%q): got %v on %v (%v), want Bullet-101 today", name, found, serviceDate, ok)
+		}
+	}
+	if found, _, ok := d.FindTrain("999", at(t, d, "2026-10-21 07:10")); ok {
+		t.Errorf("FindTrain(999): got %v, want none", found)
+	}
+}
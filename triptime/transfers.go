@@ -0,0 +1,158 @@
+package triptime
+
+import (
+	"sort"
+	"time"
+
+	"github.com/padster/triptime/fb"
+)
+
+const (
+	// Time allowed to change between platforms of the same station, or for a recommended transfer.
+	STATION_CHANGE_TIME = 3 * time.Minute
+	// Stops closer than this are assumed walkable when transfers.txt doesn't say otherwise.
+	WALK_RADIUS_KM = 0.4
+	WALK_SPEED_KMH = 4.5
+	KM_PER_DEGREE  = 111.0 // Of latitude.
+)
+
+// A platform that can be walked to (or from) when changing trains, and how long that takes.
+type change struct {
+	stopId   string
+	duration time.Duration
+}
+
+// changesFrom lists where a passenger can catch another train after arriving at a platform.
+func (d *GTFSData) changesFrom(stopId string) []change {
+	if changes, found := d.index.changesFrom[stopId]; found {
+		return changes
+	}
+	return []change{{stopId, 0}}
+}
+
+// changesTo lists the platforms a passenger can arrive at and still catch a train from this one.
+func (d *GTFSData) changesTo(stopId string) []change {
+	if changes, found := d.index.changesTo[stopId]; found {
+		return changes
+	}
+	return []change{{stopId, 0}}
+}
+
+// buildChanges works out every platform-to-platform change: staying put, other
+// platforms of the station, and walking to nearby stops, then lets transfers.txt
+// override those times or rule a change out.
+func (d *GTFSData) buildChanges() {
+	times := map[string]map[string]time.Duration{}
+	set := func(from string, to string, duration time.Duration) {
+		if times[from] == nil {
+			times[from] = map[string]time.Duration{}
+		}
+		times[from][to] = duration
+	}
+
+	platforms := []*Stop{}
+	for i, stop := range d.Stops {
+		if stop.Type == LOCATION_STOP {
+			platforms = append(platforms, &d.Stops[i])
+		}
+	}
+	for _, platform := range platforms {
+		set(platform.StopId, platform.StopId, 0)
+		for _, sibling := range d.index.platformsByStation[platform.Parent] {
+			if sibling.StopId != platform.StopId {
+				set(platform.StopId, sibling.StopId, STATION_CHANGE_TIME)
+			}
+		}
+	}
+
+	// Sweep in latitude order so only stops in a narrow band are compared.
+	sort.Sort(StopsByLatitude(platforms))
+	for i, a := range platforms {
+		aAt := &fb.Coordinates{a.Lat, a.Long}
+		for _, b := range platforms[i+1:] {
+			if (b.Lat-a.Lat)*KM_PER_DEGREE > WALK_RADIUS_KM {
+				break
+			}
+			if a.Parent != "" && a.Parent == b.Parent {
+				continue
+			}
+			dist := CoordDistKM(aAt, &fb.Coordinates{b.Lat, b.Long})
+			if dist > WALK_RADIUS_KM {
+				continue
+			}
+			walk := STATION_CHANGE_TIME + time.Duration(dist/WALK_SPEED_KMH*float64(time.Hour))
+			set(a.StopId, b.StopId, walk)
+			set(b.StopId, a.StopId, walk)
+		}
+	}
+
+	for _, transfer := range d.Transfers {
+		if transfer.FromRouteId != "" || transfer.ToRouteId != "" || transfer.FromTripId != "" || transfer.ToTripId != "" {
+			continue
+		}
+		for _, from := range d.platformsOf(transfer.FromStopId) {
+			for _, to := range d.platformsOf(transfer.ToStopId) {
+				switch transfer.Type {
+				case TRANSFER_NOT_POSSIBLE:
+					delete(times[from], to)
+				case TRANSFER_TIMED:
+					set(from, to, 0)
+				case TRANSFER_MIN_TIME:
+					set(from, to, time.Duration(transfer.MinTime)*time.Second)
+				default:
+					set(from, to, STATION_CHANGE_TIME)
+				}
+			}
+		}
+	}
+
+	d.index.changesFrom = map[string][]change{}
+	d.index.changesTo = map[string][]change{}
+	for from, tos := range times {
+		// Kept even when empty, so ruled out changes don't fall back to staying put.
+		if d.index.changesFrom[from] == nil {
+			d.index.changesFrom[from] = []change{}
+		}
+		if d.index.changesTo[from] == nil {
+			d.index.changesTo[from] = []change{}
+		}
+		for to, duration := range tos {
+			d.index.changesFrom[from] = append(d.index.changesFrom[from], change{to, duration})
+			d.index.changesTo[to] = append(d.index.changesTo[to], change{from, duration})
+		}
+	}
+}
+
+// changeTime is how long it takes to get between two platforms, false if you can't.
+func (d *GTFSData) changeTime(fromStopId string, toStopId string) (time.Duration, bool) {
+	for _, change := range d.changesFrom(fromStopId) {
+		if change.stopId == toStopId {
+			return change.duration, true
+		}
+	}
+	return 0, false
+}
+
+// platformsOf expands a station ID into its platforms, transfers.txt may name either.
+func (d *GTFSData) platformsOf(stopId string) []string {
+	platforms := []string{}
+	for _, platform := range d.index.platformsByStation[stopId] {
+		platforms = append(platforms, platform.StopId)
+	}
+	if len(platforms) == 0 {
+		platforms = append(platforms, stopId)
+	}
+	return platforms
+}
+
+type StopsByLatitude []*Stop
+
+func (stops StopsByLatitude) Len() int {
+	return len(stops)
+}
+func (stops StopsByLatitude) Swap(i, j int) {
+	stops[i], stops[j] = stops[j], stops[i]
+}
+func (stops StopsByLatitude) Less(i, j int) bool {
+	return stops[i].Lat < stops[j].Lat
+}
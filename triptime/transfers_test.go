@@ -0,0 +1,55 @@
+package triptime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlanJourneyWithTransfers(t *testing.T) {
+	// Changing at Millbrae, off the train from Palo Alto onto one of two to the airport.
+	feed := memFeed{
+		"agency.txt":   "agency_id,agency_name,agency_url,agency_timezone\nCT,Caltrain,http://caltrain.com,America/Los_Angeles\n",
+		"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nD,1,1,1,1,1,1,1,20260101,20261231\n",
+		"routes.txt":   "route_id,route_type\nCT,2\nBART,1\n",
+		"stops.txt":    "stop_id,stop_name,stop_lat,stop_lon\npa,Palo Alto,37.44,-122.16\nmb,Millbrae,37.60,-122.39\nsfo,SFO,37.62,-122.39\n",
+		"trips.txt":    "route_id,service_id,trip_id\nCT,D,ct\nBART,D,b1\nBART,D,b2\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"ct,07:10:00,07:10:00,pa,1\nct,07:25:00,07:25:00,mb,2\n" +
+			"b1,07:28:00,07:28:00,mb,1\nb1,07:38:00,07:38:00,sfo,2\n" +
+			"b2,07:40:00,07:40:00,mb,1\nb2,07:50:00,07:50:00,sfo,2\n",
+	}
+	header := "from_stop_id,to_stop_id,transfer_type,min_transfer_time,from_trip_id\n"
+	tests := []struct {
+		name      string
+		transfers string
+		want      string // Trips taken, "" if there's no way.
+	}{
+		{"no transfers.txt", "", "ct,b1"},
+		{"minimum time", header + "mb,mb,2,600,\n", "ct,b2"},
+		{"not possible", header + "mb,mb,3,,\n", ""},
+		{"not possible for another train", header + "mb,mb,3,,other\n", "ct,b1"},
+	}
+	for _, test := range tests {
+		files := memFeed{}
+		for name, contents := range feed {
+			files[name] = contents
+		}
+		if test.transfers != "" {
+			files["transfers.txt"] = test.transfers
+		}
+		d, err := LoadGTFS(files)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		itineraries := d.PlanJourney(*d.GetStop("pa"), *d.GetStop("sfo"), at(t, d, "2026-10-21 07:00"))
+		got := []string{}
+		if len(itineraries) > 0 {
+			for _, leg := range itineraries[0].Legs {
+				got = append(got, leg.Trip.TripId)
+			}
+		}
+		if strings.Join(got, ",") != test.want {
+			t.Errorf("%s: got %v, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -1,20 +1,13 @@
-// 1) Indexing in data code
-// 2) Remove trip if it's the last stop
-// 3) Add message if not stops remain
-// 4) Arrive before TT
-
 package triptime
 
 import (
 	"bytes"
-	"fmt"
 	// "log"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"strings"
-
-	// "time"
+	"unicode/utf8"
 
 	"github.com/padster/triptime/fb"
 
@@ -24,21 +17,67 @@ import (
 	"google.golang.org/appengine/urlfetch"
 )
 
+// The untracked secrets file defines these string constants:
+//   SEND_URL              Messenger Send API URL, including the page access token.
+//   MAPS_API_KEY          Google Maps key, for geocoding typed places.
+//   APP_SECRET            Facebook app secret, which webhook requests are signed with.
+//   VERIFY_TOKEN          Made up when subscribing the Messenger webhook, Facebook sends it back.
+//   TELEGRAM_BOT_TOKEN    Bot API token from BotFather.
+//   TELEGRAM_SECRET_TOKEN Made up when calling setWebhook, Telegram sends it with each update.
+//   SLACK_SIGNING_SECRET  Slack app's signing secret, which slash commands and button presses are signed with.
+//   TWILIO_AUTH_TOKEN     Twilio account's auth token, which SMS webhooks are signed with.
+
+const (
+	MAX_BUTTONS = 3 // Bot API limit
+	// Longest text Messenger takes in a button template, and in a plain text message.
+	MAX_BUTTON_TEXT  = 640
+	MAX_MESSAGE_TEXT = 2000
+	// Webhook bodies are a few messages or a short form, anything much bigger is turned
+	// away before its signature is checked, whichever platform it claims to be from.
+	MAX_WEBHOOK_BYTES = 1 << 20
+)
+
 func init() {
 	http.HandleFunc("/_/verify", verifyHandler)
 	http.HandleFunc("/policy.txt", policyHandler)
 }
 
+// Messenger webhook: the subscription handshake, then signed messages from Facebook.
 func verifyHandler(w http.ResponseWriter, r *http.Request) {
 	c := gae.NewContext(r)
-	decoder := json.NewDecoder(r.Body)
-
-	var data fb.RequestBody
-	err := decoder.Decode(&data)
+	switch r.Method {
+	case http.MethodGet:
+		challenge, ok := fb.SubscribeChallenge(r.URL.Query(), VERIFY_TOKEN)
+		if !ok {
+			log.Warningf(c, "Rejecting webhook subscription: %s", r.URL.RawQuery)
+			http.Error(w, "verify token doesn't match", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(challenge))
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MAX_WEBHOOK_BYTES))
 	if err != nil {
+		log.Errorf(c, "Can't read webhook body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	err = fb.CheckSignature(APP_SECRET, r.Header, body)
+	if !checkedSignature(c, w, "webhook", err, fb.ErrNoSecret, fb.ErrUnsigned) {
+		return
+	}
+
+	var data fb.RequestBody
+	if err := json.Unmarshal(body, &data); err != nil {
 		log.Errorf(c, "Handler error: %+v", err)
-		panic(err)
+		http.Error(w, "can't parse body", http.StatusBadRequest)
+		return
 	}
 
 	log.Infof(c, "RECV: %+v", data)
@@ -50,6 +89,36 @@ func verifyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// checkedSignature answers a webhook request whose signature didn't check out, and
+// reports whether it did. Each platform has its own errors for a missing secret and
+// a missing signature, anything else meaning it's signed by someone else.
+func checkedSignature(c ctx.Context, w http.ResponseWriter, source string, err error, errNoSecret error, errUnsigned error) bool {
+	status := signatureStatus(err, errNoSecret, errUnsigned)
+	switch status {
+	case http.StatusOK:
+		return true
+	case http.StatusInternalServerError:
+		log.Errorf(c, "Can't check %s request: %v", source, err)
+	default:
+		log.Warningf(c, "Rejecting %s request: %v", source, err)
+	}
+	http.Error(w, err.Error(), status)
+	return false
+}
+
+// signatureStatus is the HTTP status for the result of checking a webhook's signature.
+func signatureStatus(err error, errNoSecret error, errUnsigned error) int {
+	switch err {
+	case nil:
+		return http.StatusOK
+	case errNoSecret:
+		return http.StatusInternalServerError
+	case errUnsigned:
+		return http.StatusUnauthorized
+	}
+	return http.StatusForbidden
+}
+
 // Copy from https://github.com/ippy04/messengerbot/blob/master/webhook.go ?
 
 func handleMessage(c ctx.Context, e fb.Entry, msg fb.Message) {
@@ -58,122 +127,86 @@ func handleMessage(c ctx.Context, e fb.Entry, msg fb.Message) {
 		return
 	}
 
+	req := Request{
+		UserId:   msg.Sender.Id,
+		Text:     msg.Message.Text,
+		Location: getCoordinates(msg.Message),
+	}
 	if msg.Postback != nil {
-		log.Infof(c, "RECV pb: %s", msg.Postback.Payload)
-		parts := strings.Split(msg.Postback.Payload, "/")
-		switch parts[0] {
-		case "liststops":
-			handleListStops(c, msg, parts[1], parts[2])
-		default:
-			sendResponse(c, fb.OutboundMessage{
-				msg.Sender,
-				outMessageDataFromText("Ummm...I'm confused?"),
-			})
+		req.Postback = msg.Postback.Payload
+	}
+	for _, reply := range respond(c, req) {
+		for _, out := range messengerMessages(msg.Sender, reply) {
+			sendResponse(c, out)
 		}
-		return
 	}
+}
 
-	// TODO - clean up, make more pluggable.
-	pos := getCoordinates(msg.Message)
-	if pos != nil {
-		handleNextTrainRequest(c, msg.Sender, pos)
-		return
-	}
-	lowerText := strings.ToLower(strings.TrimSpace(msg.Message.Text))
-	if lowerText == "help" || lowerText == "commands" {
-		sendResponse(c, helpAction(c, msg))
-		return
-	}
-	if strings.HasPrefix(lowerText, "next") {
-		sendResponse(c, nextNLeavesAction(c, msg, strings.TrimSpace(lowerText[4:])))
-		return
+// messengerMessages renders a reply as plain text, then a button template if it
+// has choices, links or a place, keeping to Messenger's MAX_BUTTONS. Text too long
+// for the template goes ahead of it, split into messages of MAX_MESSAGE_TEXT.
+func messengerMessages(user fb.User, reply Reply) []fb.OutboundMessage {
+	buttons := []fb.Button{}
+	for _, choice := range reply.Choices {
+		buttons = append(buttons, callbackButton(choice.Caption, choice.Payload))
 	}
-	cannedResponse := cannedResponseAction(c, msg, lowerText)
-	if cannedResponse != nil {
-		sendResponse(c, *cannedResponse)
-		return
+	for _, link := range reply.Links {
+		buttons = append(buttons, urlButton(link.Caption, link.URL))
 	}
-	posFromText := maybeTextToPosition(c, msg, lowerText)
-	if posFromText != nil {
-		handleNextTrainRequest(c, msg.Sender, posFromText)
-		return
+	if reply.Location != nil {
+		buttons = append(buttons, urlButton("Map", mapsPlace(&reply.Location.At)))
 	}
-	sendResponse(c, helpAction(c, msg))
-}
-
-func handleListStops(c ctx.Context, msg fb.Message, stopId string, tripId string) {
+	parts := splitText(reply.Text, MAX_MESSAGE_TEXT)
 	text := ""
-	tripsAdded := 0
-	messageSent := false
-
-	stopTimes := SortedStopTimesForTrip(tripId)
-	found := false
-	for _, stopTime := range stopTimes {
-		if stopTime.StopId == stopId {
-			found = true
+	if len(buttons) > 0 {
+		// The template needs text of its own, the last part if it's short enough.
+		text = "👇"
+		if last := parts[len(parts)-1]; last != "" && utf8.RuneCountInString(last) <= MAX_BUTTON_TEXT {
+			text, parts = last, parts[:len(parts)-1]
 		}
-		if found {
-			shortName := shortStopName(GetStop(stopTime.StopId).Name)
-			text += fmt.Sprintf("🕓 %s - %s\n", normalizeTime(stopTime.Arrival), shortName)
-			tripsAdded++
-			// Chunk every 8 to avoid hitting message limit.
-			if tripsAdded == 8 {
-				tripsAdded = 0
-				messageSent = true
-				sendResponse(c, fb.OutboundMessage{
-					msg.Sender,
-					outMessageDataFromText(text),
-				})
-				text = ""
-			}
-		}
-	}
-	if text == "" && messageSent == false {
-		text = "I got distracted, sorry... Please ask again."
 	}
-	if text != "" {
-		sendResponse(c, fb.OutboundMessage{
-			msg.Sender,
-			outMessageDataFromText(text),
+
+	messages := []fb.OutboundMessage{}
+	for _, part := range parts {
+		messages = append(messages, fb.OutboundMessage{
+			user,
+			outMessageDataFromText(part),
 		})
 	}
-}
-
-func handleNextTrainRequest(c ctx.Context, user fb.User, pos *fb.Coordinates) {
-	t := getSFTime()
-
-	closest := ClosestStop(c, pos)
-	SetUserState(c, user.Id, UserState{
-		*pos,
-		closest,
-	})
-	closestPos := &fb.Coordinates{closest.Lat, closest.Long}
-	distToClosestKM := CoordDistKM(closestPos, pos)
-	nextTrips := NextTripsAtStop(closest)
-
-	text :=
-		fmt.Sprintf("Current time: %s\n", t.Format("15:04")) +
-			fmt.Sprintf("Closest stop: %s (%0.2fkm away)\n", closest.Name, distToClosestKM)
-	for _, trip := range nextTrips {
-		routeName := GetRoute(trip.Trip.RouteId).LongName
-		text += fmt.Sprintf(" 🚆 Next %s: %s (%s)\n", trip.Stop.PlatCode, trip.StopTime.Arrival, routeName)
+	if len(buttons) == 0 {
+		return messages
+	}
+	if len(buttons) > MAX_BUTTONS {
+		buttons = buttons[:MAX_BUTTONS]
 	}
 
 	response := buttonPayload(text)
-	for _, trip := range nextTrips {
-		caption := fmt.Sprintf("View %s stops", trip.Stop.PlatCode)
-		payload := strings.Join([]string{"liststops", trip.Stop.StopId, trip.Trip.TripId}, "/")
-		response.AddButton(callbackButton(caption, payload))
+	for _, button := range buttons {
+		response.AddButton(button)
 	}
-	response.AddButton(urlButton("Directions", mapsDirections(pos, closestPos)))
-
 	atch := templateAttachment(response)
-	sendResponse(c, fb.OutboundMessage{
+	return append(messages, fb.OutboundMessage{
 		user,
 		outMessageDataFromAttachment(&atch),
 	})
 }
 
+// splitText breaks text into parts of at most max characters, at the end of a
+// line where there's one to break at.
+func splitText(text string, max int) []string {
+	parts := []string{}
+	runes := []rune(text)
+	for len(runes) > max {
+		n := max
+		if i := strings.LastIndex(string(runes[:max]), "\n"); i > 0 {
+			n = utf8.RuneCountInString(string(runes[:max])[:i])
+		}
+		parts = append(parts, string(runes[:n]))
+		runes = []rune(strings.TrimPrefix(string(runes[n:]), "\n"))
+	}
+	return append(parts, string(runes))
+}
+
 // TODO - move these utilities into FB package
 func outMessageDataFromText(text string) fb.OutMessageData {
 	return fb.OutMessageData{text, nil}
@@ -215,19 +248,18 @@ func sendResponse(c ctx.Context, msg fb.OutboundMessage) {
 			log.Errorf(c, "Send error: %+v", e)
 			panic("Can't send")
 		}
+		defer r.Body.Close()
 		response, _ := ioutil.ReadAll(r.Body)
+		if r.StatusCode != http.StatusOK {
+			log.Errorf(c, "Send API error %s: %s", r.Status, string(response))
+			return
+		}
 		log.Infof(c, "received back: %s", string(response))
 	} else {
 		log.Infof(c, "...or not, skipping on dev app server")
 	}
 }
 
-func mapsDirections(from *fb.Coordinates, to *fb.Coordinates) string {
-	return fmt.Sprintf(
-		"https://www.google.com.au/maps/dir/%.7f,%.7f/'%.7f,%.7f'",
-		from.Lat, from.Long, to.Lat, to.Long)
-}
-
 func buttonPayload(text string) fb.ButtonPayload {
 	return fb.ButtonPayload{
 		"button",
@@ -254,15 +286,6 @@ func callbackButton(caption string, payload string) fb.Button {
 	}
 }
 
-func shortStopName(name string) string {
-	pos := strings.LastIndex(name, " Caltrain")
-	if pos != -1 {
-		return name[:pos]
-	} else {
-		return name
-	}
-}
-
 // Serve user data policy static page
 func policyHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
@@ -273,6 +296,8 @@ Information provided by each user is stored for only 10 minutes
 Location information (if provided) is used to find local time and nearby transport only,
   and accessible only to bot in that 10 minutes,
   not shared with others.
+A Slack team's office station (if set) is kept until the team changes it,
+  and is the only thing kept for the team.
 No other details about users are handled.
     `))
 }
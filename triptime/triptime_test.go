@@ -0,0 +1,83 @@
+package triptime
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/padster/triptime/fb"
+	"github.com/padster/triptime/slack"
+	"github.com/padster/triptime/twilio"
+)
+
+// messengerText is each message's text, a template's marked with its buttons' count.
+func messengerText(t *testing.T, messages []fb.OutboundMessage) []string {
+	texts := []string{}
+	for _, msg := range messages {
+		if msg.Message.Attachment == nil {
+			if n := utf8.RuneCountInString(msg.Message.Text); n > MAX_MESSAGE_TEXT {
+				t.Errorf("got %d characters of text, want at most %d", n, MAX_MESSAGE_TEXT)
+			}
+			texts = append(texts, msg.Message.Text)
+			continue
+		}
+		payload := msg.Message.Attachment.Payload.(fb.ButtonPayload)
+		if n := utf8.RuneCountInString(payload.Text); n > MAX_BUTTON_TEXT {
+			t.Errorf("got %d characters of template text, want at most %d", n, MAX_BUTTON_TEXT)
+		}
+		texts = append(texts, strings.Repeat("[]", len(payload.Buttons))+payload.Text)
+	}
+	return texts
+}
+
+func TestMessengerMessages(t *testing.T) {
+	line := strings.Repeat("x", 499)
+	choices := []Choice{{"A", "a"}, {"B", "b"}, {"C", "c"}, {"D", "d"}}
+	tests := []struct {
+		name  string
+		reply Reply
+		want  []string
+	}{
+		{"text", Reply{Text: "short"}, []string{"short"}},
+		{"buttons", Reply{Text: "short", Choices: choices[:2]}, []string{"[][]short"}},
+		{"no more than three buttons", Reply{Text: "short", Choices: choices}, []string{"[][][]short"}},
+		{"long text", Reply{Text: strings.Repeat(line+"\n", 9) + line},
+			[]string{strings.Repeat(line+"\n", 3) + line, strings.Repeat(line+"\n", 3) + line, line + "\n" + line}},
+		{"too long for a template", Reply{Text: line + "\n" + line, Choices: choices[:1]},
+			[]string{line + "\n" + line, "[]👇"}},
+		{"last part goes with the buttons", Reply{Text: strings.Repeat(line+"\n", 4) + "end", Choices: choices[:1]},
+			[]string{strings.Repeat(line+"\n", 3) + line, "[]end"}},
+		{"no line to split at", Reply{Text: strings.Repeat("é", 2500)},
+			[]string{strings.Repeat("é", 2000), strings.Repeat("é", 500)}},
+	}
+	for _, test := range tests {
+		got := messengerText(t, messengerMessages(fb.User{"1"}, test.reply))
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSignatureStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		errNoSecret error
+		errUnsigned error
+		want        int
+	}{
+		{"signed", nil, fb.ErrNoSecret, fb.ErrUnsigned, http.StatusOK},
+		{"no app secret", fb.ErrNoSecret, fb.ErrNoSecret, fb.ErrUnsigned, http.StatusInternalServerError},
+		{"no Slack signature", slack.ErrUnsigned, slack.ErrNoSecret, slack.ErrUnsigned, http.StatusUnauthorized},
+		{"replayed from Slack", slack.ErrStale, slack.ErrNoSecret, slack.ErrUnsigned, http.StatusForbidden},
+		// The platforms' errors read the same, but only their own count.
+		{"Slack's missing signature for Twilio", slack.ErrUnsigned, twilio.ErrNoSecret, twilio.ErrUnsigned, http.StatusForbidden},
+		{"signed by someone else", twilio.ErrBadSignature, twilio.ErrNoSecret, twilio.ErrUnsigned, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		if got := signatureStatus(test.err, test.errNoSecret, test.errUnsigned); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+	}
+}
@@ -28,14 +28,12 @@ func GetUserState(c ctx.Context, userID string) *UserState {
 	}
 }
 
-func NeedUserState(c ctx.Context, msg fb.Message) (*UserState, *fb.OutboundMessage) {
-	state := GetUserState(c, msg.Sender.Id)
+func NeedUserState(c ctx.Context, req Request) (*UserState, *Reply) {
+	state := GetUserState(c, req.UserId)
 	if state == nil {
 		text := "Hey, sorry, forgot where you are ☹ - can you send it again."
-		return nil, &fb.OutboundMessage{
-			msg.Sender,
-			outMessageDataFromText(text),
-		}
+		reply := textReply(text)
+		return nil, &reply
 	} else {
 		return state, nil
 	}
@@ -0,0 +1,101 @@
+package triptime
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	ctx "golang.org/x/net/context"
+)
+
+// Whether text asks after a train: "where is train 123", "where's 123".
+func isWhereIsRequest(lowerText string) bool {
+	return strings.HasPrefix(lowerText, "where is ") || strings.HasPrefix(lowerText, "where's ")
+}
+
+// Given a train number, say where that train is and when it gets to its next stop.
+func whereIsAction(c ctx.Context, req Request, lowerText string) Reply {
+	name := strings.TrimPrefix(strings.TrimPrefix(lowerText, "where is "), "where's ")
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "train "), "#")
+	name = strings.TrimSpace(strings.TrimSuffix(name, "?"))
+	if name == "" {
+		return whereIsUsage()
+	}
+
+	t := DATA.Now()
+	trip, serviceDate, found := DATA.FindTrain(name, t)
+	if !found {
+		return textReply(fmt.Sprintf("Sorry, I can't find a train %s running today.", name))
+	}
+	return trainLocationReply(trip, serviceDate, t)
+}
+
+// Postback from a trip's stop list, asking where that train has got to.
+func whereIsPostback(c ctx.Context, req Request, tripId string, serviceDate string) Reply {
+	trip := DATA.GetTrip(tripId)
+	date, err := time.ParseInLocation("20060102", serviceDate, DATA.Location)
+	if trip == nil || err != nil {
+		return textReply("I got distracted, sorry... Please ask again.")
+	}
+	return trainLocationReply(trip, date, DATA.Now())
+}
+
+func trainLocationReply(trip *Trip, serviceDate time.Time, t time.Time) Reply {
+	text := fmt.Sprintf("🚆 %s %s\n", DATA.RouteLabel(trip.RouteId), trainName(trip))
+	loc, running := DATA.LocateTrain(trip, serviceDate, t)
+	if !running {
+		stopTimes := DATA.SortedStopTimesForTrip(trip.TripId)
+		if len(stopTimes) > 0 && stopTimes[0].Departure.On(serviceDate).After(t) {
+			if first := DATA.GetStop(stopTimes[0].StopId); first != nil {
+				text += fmt.Sprintf("Hasn't set off yet, it leaves %s at %s.\n", shortStopName(first.Name), stopTimes[0].Departure)
+			}
+		} else {
+			text += "Has finished its trip.\n"
+		}
+		return textReply(text)
+	}
+
+	source := "going by the timetable"
+	if loc.Live {
+		source = "live"
+	}
+	switch {
+	case loc.Stopped:
+		text += fmt.Sprintf("📍 At %s (%s)\n", shortStopName(loc.NextStop.Name), source)
+		text += fmt.Sprintf("Leaving at %s\n", loc.Prediction.Describe(loc.NextStopTime.Departure, loc.Prediction.DepartureDelay))
+	case loc.LastStop != nil:
+		text += fmt.Sprintf("📍 Between %s and %s (%s)\n", shortStopName(loc.LastStop.Name), shortStopName(loc.NextStop.Name), source)
+		text += fmt.Sprintf("Next stop: %s, due %s\n", shortStopName(loc.NextStop.Name), loc.NextArrivalText())
+	default:
+		text += fmt.Sprintf("📍 On the way to %s (%s)\n", shortStopName(loc.NextStop.Name), source)
+		text += fmt.Sprintf("Next stop: %s, due %s\n", shortStopName(loc.NextStop.Name), loc.NextArrivalText())
+	}
+
+	reply := textReply(text)
+	reply.Location = &Place{"Train " + trainName(trip), loc.Position}
+	reply.AddChoice("View stops", listStopsPayload(loc.NextStop, trip, serviceDate))
+	return reply
+}
+
+// trainName is how riders know a train, e.g. "#123 to San Francisco".
+func trainName(trip *Trip) string {
+	name := ""
+	if trip.ShortName != "" {
+		name = "#" + trip.ShortName
+	}
+	if trip.HeadSign != "" {
+		name = strings.TrimSpace(name + " to " + trip.HeadSign)
+	}
+	return name
+}
+
+// whereIsPayload is the postback for a button asking where a train is now.
+func whereIsPayload(trip *Trip, serviceDate time.Time) string {
+	return strings.Join([]string{"whereis", trip.TripId, dateAsString(serviceDate)}, "/")
+}
+
+func whereIsUsage() Reply {
+	usage := "Try in the form: Where is train [number]\n"
+	usage += "e.g. Where is train 123"
+	return textReply(usage)
+}
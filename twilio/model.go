@@ -0,0 +1,37 @@
+package twilio
+
+import (
+	"encoding/xml"
+	"net/url"
+)
+
+// Message is the form Twilio POSTs for an incoming SMS.
+type Message struct {
+	MessageSid string
+	From       string // E.164, e.g. +14155550100
+	To         string
+	Body       string
+}
+
+func ParseMessage(form url.Values) Message {
+	return Message{
+		form.Get("MessageSid"),
+		form.Get("From"),
+		form.Get("To"),
+		form.Get("Body"),
+	}
+}
+
+// Response is TwiML answering a webhook, each Message sent back as an SMS.
+type Response struct {
+	XMLName  xml.Name `xml:"Response"`
+	Messages []string `xml:"Message"`
+}
+
+func (r Response) Marshal() ([]byte, error) {
+	body, err := xml.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
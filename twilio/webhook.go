@@ -0,0 +1,57 @@
+package twilio
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+var (
+	// ErrUnsigned is a webhook request without an X-Twilio-Signature header.
+	ErrUnsigned = errors.New("request is not signed")
+	// ErrBadSignature is a signature that doesn't match the request, so it didn't come from Twilio.
+	ErrBadSignature = errors.New("request signature doesn't match")
+	// ErrNoSecret is an empty auth token, the one on the Twilio console that the account's
+	// webhooks are signed with.
+	ErrNoSecret = errors.New("no auth token to check the signature with")
+)
+
+// CheckSignature verifies the HMAC-SHA1 Twilio signs webhooks with, using the account's
+// auth token, over the full URL it requested followed by each POST parameter's name and
+// value, sorted by name (and by value for repeated ones).
+func CheckSignature(authToken string, header http.Header, webhookURL string, form url.Values) error {
+	if authToken == "" {
+		return ErrNoSecret
+	}
+	signature := header.Get("X-Twilio-Signature")
+	if signature == "" {
+		return ErrUnsigned
+	}
+	given, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrBadSignature
+	}
+
+	names := []string{}
+	for name := range form {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(webhookURL))
+	for _, name := range names {
+		values := append([]string{}, form[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			mac.Write([]byte(name + value))
+		}
+	}
+	if !hmac.Equal(given, mac.Sum(nil)) {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package twilio
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestCheckSignature(t *testing.T) {
+	webhookURL := "https://example.com/_/sms?agency=ct"
+	// What Twilio signs: the URL, then parameters sorted by name, repeated ones by value.
+	signed := webhookURL + "BodyPalo Alto" + "From+14155550100" + "MediaUrlhttps://a" + "MediaUrlhttps://b"
+	mac := hmac.New(sha1.New, []byte("s3cret"))
+	mac.Write([]byte(signed))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name      string
+		signature string
+		url       string
+		form      url.Values
+		want      error
+	}{
+		{"signed", signature, webhookURL,
+			url.Values{"From": {"+14155550100"}, "Body": {"Palo Alto"}, "MediaUrl": {"https://a", "https://b"}}, nil},
+		{"repeated values in another order", signature, webhookURL,
+			url.Values{"From": {"+14155550100"}, "Body": {"Palo Alto"}, "MediaUrl": {"https://b", "https://a"}}, nil},
+		{"body changed", signature, webhookURL,
+			url.Values{"From": {"+14155550100"}, "Body": {"Millbrae"}, "MediaUrl": {"https://a", "https://b"}}, ErrBadSignature},
+		{"without the query string", signature, "https://example.com/_/sms",
+			url.Values{"From": {"+14155550100"}, "Body": {"Palo Alto"}, "MediaUrl": {"https://a", "https://b"}}, ErrBadSignature},
+		{"not base64", "!!", webhookURL, url.Values{}, ErrBadSignature},
+		{"unsigned", "", webhookURL, url.Values{}, ErrUnsigned},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.signature != "" {
+			header.Set("X-Twilio-Signature", test.signature)
+		}
+		if got := CheckSignature("s3cret", header, test.url, test.form); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("X-Twilio-Signature", signature)
+	if got := CheckSignature("", header, webhookURL, url.Values{}); got != ErrNoSecret {
+		t.Errorf("no auth token: got %v, want %v", got, ErrNoSecret)
+	}
+}